@@ -0,0 +1,142 @@
+package jd
+
+import (
+	gojson "encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ErrNotFound is returned when a path does not exist.
+var ErrNotFound = errors.New("path not found")
+
+// ErrInvalid is returned when the input is not valid json.
+var ErrInvalid = errors.New("not valid json")
+
+// Get returns the raw json value at path, using the same path syntax as
+// the editor.
+func Get(json []byte, path string) ([]byte, error) {
+	if !gojson.Valid(json) {
+		return nil, ErrInvalid
+	}
+	var res gjson.Result
+	if path == "" {
+		res, _ = locate(json, "")
+	} else {
+		res = gjson.GetBytes(json, path)
+	}
+	if !res.Exists() {
+		return nil, ErrNotFound
+	}
+	return []byte(res.Raw), nil
+}
+
+// Set sets the value at path the same way the edit bar does. When value is
+// valid json it is used as is, otherwise it's stored as a string.
+func Set(json []byte, path, value string) ([]byte, error) {
+	return settyped(json, path, value, typeAuto)
+}
+
+// SetString sets the value at path to a string.
+func SetString(json []byte, path, value string) ([]byte, error) {
+	return settyped(json, path, value, typeString)
+}
+
+// SetRaw sets the value at path to raw json. It fails when value is not
+// valid json.
+func SetRaw(json []byte, path, value string) ([]byte, error) {
+	return settyped(json, path, value, typeJSON)
+}
+
+func settyped(json []byte, path, value string, t valuetype) ([]byte, error) {
+	if !gojson.Valid(json) {
+		return nil, ErrInvalid
+	}
+	raw, err := typedvalue(value, t)
+	if err != nil {
+		return nil, err
+	}
+	return setraw(json, path, raw)
+}
+
+// Delete deletes the value at path. An empty path deletes the whole
+// document.
+func Delete(json []byte, path string) ([]byte, error) {
+	if _, err := Get(json, path); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return []byte{}, nil
+	}
+	return sjson.DeleteBytes(json, path)
+}
+
+// Export returns the array of objects at path as a table, where format is
+// "csv", "tsv" or "md". Nested objects are flattened to dotted columns.
+func Export(json []byte, path, format string) ([]byte, error) {
+	f, ok := formatof("." + format)
+	if !ok || !f.tabular() {
+		return nil, fmt.Errorf("unknown table format %q", format)
+	}
+	raw, err := Get(json, path)
+	if err != nil {
+		return nil, err
+	}
+	return jsontotable(f, raw)
+}
+
+// Document is the json of a file, which is written back in the format and
+// the compression the file was read in. YAML, TOML, JSON Lines and JSONC
+// files are converted the same way as in the editor.
+type Document struct {
+	json  []byte
+	f     format
+	c     *jsonc
+	codec codec
+	lost  []string
+}
+
+// Decode returns the document in the content b of the file at path. The
+// format comes from the extension of path, or else from the content.
+func Decode(path string, b []byte) (*Document, error) {
+	f, json, c, lost, err := decodefile(path, b)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{json: json, f: f, c: c, codec: detectcodec(b), lost: lost}, nil
+}
+
+// JSON returns the json of the document.
+func (d *Document) JSON() []byte {
+	return d.json
+}
+
+// Update replaces the json of the document. It fails when the file has
+// something that json can't hold, like YAML comments, which would be
+// dropped by writing it.
+func (d *Document) Update(json []byte) error {
+	if len(d.lost) > 0 {
+		return fmt.Errorf("writing drops the %s", strings.Join(d.lost, ", "))
+	}
+	op := makeundoop("", d.json, json)
+	d.c.splice(d.json, op.pos, len(op.prev), op.next)
+	d.json = json
+	return nil
+}
+
+// Encode returns the content of the file for the document.
+func (d *Document) Encode() ([]byte, error) {
+	out := d.json
+	if d.c != nil {
+		out = d.c.source(d.json)
+	} else {
+		var err error
+		if out, err = encode(d.f, d.json); err != nil {
+			return nil, err
+		}
+	}
+	return compress(d.codec, out)
+}
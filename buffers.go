@@ -0,0 +1,154 @@
+package jd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// errSwitch is returned by runloop when the session switches to another
+// buffer.
+var errSwitch = errors.New("switch buffer")
+
+// session is the state that is shared by all open buffers.
+type session struct {
+	bufs      []*Editor
+	cur       int
+	registers map[rune]clip
+}
+
+// run runs the current buffer until the editor exits.
+func (s *session) run() error {
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+	termbox.SetOutputMode(termbox.Output256)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watch(stop)
+	for {
+		e := s.bufs[s.cur]
+		e.reflow()
+		if err := e.runloop(); err != errSwitch {
+			return err
+		}
+	}
+}
+
+func (s *session) close() {
+	for _, e := range s.bufs {
+		if e.journal != nil && !e.modified() {
+			// the history is kept for the next time the file is opened,
+			// relative to what was written
+			e.journal.rebase(e, e.json)
+		}
+		e.journal.close()
+	}
+}
+
+// switchto makes the buffer at idx the current buffer.
+func (e *Editor) switchto(idx int) error {
+	s := e.sess
+	idx = (idx%len(s.bufs) + len(s.bufs)) % len(s.bufs)
+	e.listmode = false
+	if idx == s.cur {
+		e.redraw()
+		return nil
+	}
+	s.cur = idx
+	return errSwitch
+}
+
+func (e *Editor) nextbuffer() error {
+	if len(e.sess.bufs) == 1 {
+		e.seterr(errors.New("no other buffers"))
+		e.redraw()
+		return nil
+	}
+	return e.switchto(e.sess.cur + 1)
+}
+
+func (e *Editor) openlist() {
+	e.listmode = true
+	e.listidx = e.sess.cur
+	e.redraw()
+}
+
+// listkey handles a key in the buffer list.
+func (e *Editor) listkey(ev termbox.Event) error {
+	switch ev.Key {
+	default:
+		if ev.Ch >= '1' && ev.Ch <= '9' && int(ev.Ch-'1') < len(e.sess.bufs) {
+			return e.switchto(int(ev.Ch - '1'))
+		}
+		if ev.Ch == 0 && ev.Key == 3 {
+			// Ctrl-C, close the list
+			e.listmode = false
+		}
+	case termbox.KeyEsc:
+		e.listmode = false
+	case termbox.KeyArrowUp:
+		if e.listidx > 0 {
+			e.listidx--
+		}
+	case termbox.KeyArrowDown:
+		if e.listidx < len(e.sess.bufs)-1 {
+			e.listidx++
+		}
+	case termbox.KeyEnter:
+		return e.switchto(e.listidx)
+	}
+	e.redraw()
+	return nil
+}
+
+// blitlist draws the buffer list in place of the result.
+func (e *Editor) blitlist() {
+	e.resy = e.y
+	for i, buf := range e.sess.bufs {
+		y := e.resy + i
+		if y >= e.h-2 {
+			break
+		}
+		var mod string
+		if buf.modified() {
+			mod = " [modified]"
+		}
+		line := []rune(fmt.Sprintf(" %d  %s%s", i+1, buf.name, mod))
+		fg, bg := termbox.Attribute(lightGray), termbox.ColorDefault
+		if i == e.listidx {
+			fg, bg = termbox.ColorBlack, termbox.ColorWhite
+		}
+		for x := 0; x < e.w; x++ {
+			c := ' '
+			if x < len(line) {
+				c = line[x]
+			}
+			termbox.SetCell(x, y, c, fg, bg)
+		}
+	}
+}
+
+// blitbufname draws the name of the buffer at the end of the help bar when
+// there's more than one buffer, and the record shown for json lines.
+func (e *Editor) blitbufname() {
+	var label string
+	if e.sess != nil && len(e.sess.bufs) > 1 {
+		label = fmt.Sprintf(" [%d/%d %s]", e.sess.cur+1, len(e.sess.bufs), e.name)
+	}
+	label += e.recordlabel()
+	if label == "" {
+		return
+	}
+	label += " "
+	x := e.w - len(label)
+	if x < 0 {
+		x = 0
+	}
+	for _, c := range label {
+		termbox.SetCell(x, e.h-1, c, termbox.ColorBlack, termbox.ColorWhite)
+		x++
+	}
+}
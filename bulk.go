@@ -0,0 +1,262 @@
+package jd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/match"
+	"github.com/tidwall/sjson"
+)
+
+// splitquery splits a path into its components. Unlike parsePath it keeps
+// the escapes and does not split on dots inside of a '#[...]' query.
+func splitquery(path string) []string {
+	var parts []string
+	var s, depth int
+	var quoted bool
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\':
+			i++
+		case quoted:
+			if path[i] == '"' {
+				quoted = false
+			}
+		case path[i] == '"' && depth > 0:
+			quoted = true
+		case path[i] == '[':
+			depth++
+		case path[i] == ']':
+			depth--
+		case path[i] == '.' && depth == 0:
+			parts = append(parts, path[s:i])
+			s = i + 1
+		}
+	}
+	return append(parts, path[s:])
+}
+
+// expandpath returns the concrete path of every value in json that a path
+// with wildcards, '#' or '#[...]' queries matches, in document order.
+func expandpath(json []byte, path string) []string {
+	root, ok := locate(json, "")
+	if !ok {
+		return nil
+	}
+	var paths []string
+	var walk func(val gjson.Result, vpath string, parts []string)
+	walk = func(val gjson.Result, vpath string, parts []string) {
+		if len(parts) == 0 {
+			paths = append(paths, vpath)
+			return
+		}
+		if val.Type != gjson.JSON {
+			return
+		}
+		part := parts[0]
+		if val.Raw[0] == '[' {
+			var els []gjson.Result
+			val.ForEach(func(_, el gjson.Result) bool {
+				els = append(els, el)
+				return true
+			})
+			switch {
+			case part == "#":
+				if len(parts) == 1 {
+					// the count of an array is not a value
+					return
+				}
+				for i, el := range els {
+					walk(el, joinpath(vpath, strconv.Itoa(i)), parts[1:])
+				}
+			case strings.HasPrefix(part, "#["):
+				all := strings.HasSuffix(part, "]#")
+				query := part
+				if all {
+					query = part[:len(part)-1]
+				}
+				for i, el := range els {
+					if !gjson.Get("["+el.Raw+"]", query).Exists() {
+						continue
+					}
+					walk(el, joinpath(vpath, strconv.Itoa(i)), parts[1:])
+					if !all {
+						break
+					}
+				}
+			default:
+				i, err := strconv.Atoi(part)
+				if err == nil && i >= 0 && i < len(els) {
+					walk(els[i], joinpath(vpath, part), parts[1:])
+				}
+			}
+			return
+		}
+		names, _ := parsePath(part)
+		name := names[0]
+		wild := strings.IndexAny(unescapedwild(part), "*?") != -1
+		val.ForEach(func(key, el gjson.Result) bool {
+			if wild && match.Match(key.String(), name) || !wild && key.String() == name {
+				walk(el, joinpath(vpath, key.String()), parts[1:])
+				return wild
+			}
+			return true
+		})
+	}
+	walk(root, "", splitquery(path))
+	return paths
+}
+
+// unescapedwild returns part without the escaped characters, which leaves
+// only the wildcards that are meant as wildcards.
+func unescapedwild(part string) string {
+	var b []byte
+	for i := 0; i < len(part); i++ {
+		if part[i] == '\\' {
+			i++
+			continue
+		}
+		b = append(b, part[i])
+	}
+	return string(b)
+}
+
+// startbulk previews every location that the path matches. An edit or a
+// delete then applies to all of them at once.
+func (e *Editor) startbulk() {
+	paths := expandpath(e.json, e.apath())
+	if len(paths) == 0 {
+		e.seterr(errors.New("no matches"))
+		e.redraw()
+		return
+	}
+	e.bulkmode = true
+	e.bulkpath = e.path
+	e.bulkpaths = paths
+	e.bulkmatch()
+	e.redraw()
+}
+
+// bulkmatch finds the matches in the formatted json for highlighting.
+func (e *Editor) bulkmatch() {
+	e.bulkres = nil
+	for _, path := range expandpath([]byte(e.root.Raw), e.bulkpath) {
+		if res, ok := locate([]byte(e.root.Raw), path); ok {
+			e.bulkres = append(e.bulkres, res)
+		}
+	}
+	sort.Slice(e.bulkres, func(i, j int) bool {
+		return e.bulkres[i].Index < e.bulkres[j].Index
+	})
+}
+
+func (e *Editor) stopbulk() {
+	e.bulkmode = false
+	e.bulkpaths = nil
+	e.bulkres = nil
+	e.redraw()
+}
+
+// completebulkedit sets the edit bar value at every match.
+func (e *Editor) completebulkedit() {
+	raw, err := typedvalue(e.editval, e.edittype)
+	if err != nil {
+		e.seterr(err)
+		e.redraw()
+		return
+	}
+	njson := e.json
+	for _, path := range e.bulkpaths {
+		njson, err = setraw(njson, path, raw)
+		if err != nil {
+			e.seterr(err)
+			e.stopedit()
+			e.redraw()
+			return
+		}
+	}
+	e.commit(e.apath(), njson)
+	e.seterr(fmt.Errorf("set %d values", len(e.bulkpaths)))
+	e.stopedit()
+	e.bulkmode = false
+	e.bulkpaths, e.bulkres = nil, nil
+	e.reflow()
+}
+
+// bulkdelete deletes every match. The matches are deleted from last to
+// first so that removing an array element does not move the ones that
+// are still to be deleted.
+func (e *Editor) bulkdelete() {
+	njson := e.json
+	for i := len(e.bulkpaths) - 1; i >= 0; i-- {
+		var err error
+		njson, err = sjson.DeleteBytes(njson, e.bulkpaths[i])
+		if err != nil {
+			e.seterr(err)
+			e.redraw()
+			return
+		}
+	}
+	e.commit(e.apath(), njson)
+	e.seterr(fmt.Errorf("deleted %d values", len(e.bulkpaths)))
+	e.bulkmode = false
+	e.bulkpaths, e.bulkres = nil, nil
+	e.reflow()
+}
+
+// blitbulk draws the result with every match highlighted.
+func (e *Editor) blitbulk() {
+	e.resy = e.y
+	e.fg = lightGray
+	defer e.resetcolors()
+	if e.lazy != nil {
+		var spans []lazyspan
+		for _, res := range e.bulkres {
+			spans = append(spans, lazyspan{res.Index, res.Index + len(res.Raw), highlight})
+		}
+		top := 0
+		if len(spans) > 0 {
+			e.scrollintoview(spans[0].start, spans[0].end-spans[0].start)
+			top = e.scrolly
+		}
+		e.blitlarge(top, spans)
+		return
+	}
+	if len(e.bulkres) > 0 {
+		first := e.bulkres[0]
+		e.scrollintoview(first.Index, len(first.Raw))
+	}
+	var s int
+	for _, res := range e.bulkres {
+		if res.Index < s {
+			continue
+		}
+		e.blitstr(e.blitroot(s, res.Index))
+		e.fg = highlight
+		e.blitstr(e.blitroot(res.Index, res.Index+len(res.Raw)))
+		e.fg = lightGray
+		s = res.Index + len(res.Raw)
+	}
+	e.blitstr(e.blitroot(s, len(e.root.Raw)))
+}
+
+func (e *Editor) blitbulkstatus() {
+	e.statusy = e.y
+	e.barval = ""
+	e.bg = statusBar
+	e.fg = termbox.ColorWhite | termbox.AttrBold
+	barstr := fmt.Sprintf("%d matches", len(e.bulkpaths))
+	if len(barstr) > e.w {
+		barstr = barstr[:e.w]
+	} else {
+		barstr += strings.Repeat(" ", e.w-len(barstr))
+	}
+	e.newline()
+	e.blitstr(barstr)
+	e.resetcolors()
+}
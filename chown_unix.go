@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package jd
+
+import (
+	"os"
+	"syscall"
+)
+
+func chown(path string, fi os.FileInfo) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		os.Chown(path, int(st.Uid), int(st.Gid))
+	}
+}
@@ -0,0 +1,166 @@
+package jd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tidwall/gjson"
+)
+
+// defaultRegister is the register used when no other register is chosen
+// with ^G.
+const defaultRegister = '"'
+
+// clip is a copied value. The key is kept for object members so that the
+// value can be pasted into another object under the same name.
+type clip struct {
+	key    string
+	haskey bool
+	raw    []byte // dedented raw value
+}
+
+// register returns the register for the next copy, cut or paste and goes
+// back to the default register.
+func (e *Editor) register() rune {
+	r := e.nextreg
+	e.nextreg = 0
+	if r == 0 {
+		r = defaultRegister
+	}
+	return r
+}
+
+// selectregister chooses the register for the next copy, cut or paste.
+func (e *Editor) selectregister(r rune) {
+	e.selectreg = false
+	if r < 'a' || r > 'z' {
+		e.seterr(errors.New("registers are a-z"))
+	} else {
+		e.nextreg = r
+		e.seterr(fmt.Errorf("register %c", r))
+	}
+	e.redraw()
+}
+
+// copyclip copies the selected value into a register.
+func (e *Editor) copyclip() (clip, bool) {
+	e.completehint(false)
+	res, ok := locate(e.json, e.apath())
+	if !ok {
+		e.seterr(errors.New("nothing to copy"))
+		return clip{}, false
+	}
+	c := clip{raw: dedent(e.json, res.Index, []byte(res.Raw))}
+	if _, ok := locatekey(e.json, e.apath()); ok {
+		_, c.key = splitpath(e.apath())
+		c.haskey = true
+	}
+	r := e.register()
+	e.sess.registers[r] = c
+	if e.osc52 {
+		osc52(c.raw)
+	}
+	return c, true
+}
+
+func (e *Editor) copy() {
+	if _, ok := e.copyclip(); ok {
+		e.seterr(errors.New("copied"))
+	}
+	e.redraw()
+}
+
+// cut copies the selected value and deletes it.
+func (e *Editor) cut() {
+	if _, ok := e.copyclip(); !ok {
+		e.redraw()
+		return
+	}
+	e.delete()
+}
+
+// paste replaces the value at the path with the register, or creates it
+// when the path does not exist yet. When insert is true the register goes
+// after the selected array element instead, or into the selected object
+// under its copied key.
+func (e *Editor) paste(insert bool) {
+	r := e.register()
+	c, ok := e.sess.registers[r]
+	if !ok {
+		e.seterr(fmt.Errorf("register %c is empty", r))
+		e.redraw()
+		return
+	}
+	if !insert {
+		if e.invalid && e.fullhintpath != "" {
+			e.completehint(false)
+		}
+		njson, err := setraw(e.json, e.apath(), c.raw)
+		if err != nil {
+			e.seterr(err)
+		} else {
+			e.commit(e.apath(), njson)
+		}
+		e.reflow()
+		return
+	}
+	e.completehint(false)
+	if _, ok := locateel(e.json, e.apath()); ok {
+		njson, path, err := insertel(e.json, e.apath(), c.raw, true)
+		if err != nil {
+			e.seterr(err)
+		} else {
+			e.commit(e.apath(), njson)
+			e.setpath(path)
+		}
+		e.reflow()
+		return
+	}
+	res, ok := locate(e.json, e.apath())
+	if !ok || res.Type != gjson.JSON || res.Raw[0] != '{' {
+		e.seterr(errors.New("select an array element or object to insert into"))
+		e.redraw()
+		return
+	}
+	if !c.haskey {
+		e.seterr(errors.New("register has no key, paste at a new path instead"))
+		e.redraw()
+		return
+	}
+	if e.apath() == "" && c.key == "" {
+		e.seterr(errors.New("a top-level key can't be empty"))
+		e.redraw()
+		return
+	}
+	path := joinpath(e.apath(), c.key)
+	if _, ok := locatekey(e.json, path); ok {
+		e.seterr(errors.New("duplicate key"))
+		e.redraw()
+		return
+	}
+	njson, err := setraw(e.json, path, c.raw)
+	if err != nil {
+		e.seterr(err)
+	} else {
+		e.commit(path, njson)
+		e.setpath(path)
+	}
+	e.reflow()
+}
+
+// osc52 sends b to the system clipboard through the terminal with the
+// OSC 52 escape sequence.
+func osc52(b []byte) {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer tty.Close()
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString(b) + "\x07"
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;\x1b" + seq + "\x1b\\"
+	}
+	tty.Write([]byte(seq))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
@@ -12,28 +13,226 @@ import (
 var (
 	usage = `
 jd - JSON Interactive Editor
-usage: jd path
+usage: jd [-j] [-c] [-b] [--schema file] path...
+       jd get file path
+       jd set file path value [--raw|--string]
+       jd del file path
+       jd export file path [--csv|--tsv|--md]
+       jd diff [-u] [--id key] file file
+
+options:
+       -j                     Keep the undo history in a journal file
+       -c                     Copy to the system clipboard with OSC 52
+       -b                     Keep a '.bak' file of the previous content on write
+       --schema file          Validate against a JSON Schema, instead of the
+                              local file in the '$schema' key
+       --raw                  Set the value as raw json, which must be valid
+       --string               Set the value as a string
+       --csv, --tsv, --md     Export as CSV, TSV or a Markdown table
+       -u                     Show a diff in one column instead of two
+       --id key               Match the objects in arrays by the value of
+                              key in a diff, instead of by index
+
+commands:
+       get                    Print the json value at path
+       set                    Set the value at path, typed like the edit bar
+       del                    Delete the value at path
+       export                 Print the array of objects at path as a table
+       diff                   Show the changes between two files and copy
+                              them from one to the other
+
+       A file of '-' reads from stdin and writes to stdout, otherwise the
+       file is changed in place. The exit code is 1 when the path does
+       not exist and 2 for any other error.
 
 examples:
        jd user.json           Open a file named 'user.json'
+       jd -j user.json        Open with a persistent undo journal
+       jd a.json b.json       Open each file in its own buffer
+       jd events.jsonl        Open a JSON Lines file record by record
+       jd config.yaml         Edit a YAML file, or a TOML file
+       jd tsconfig.json       Edit a JSONC file, keeping its comments
+       jd dump.json.gz        Edit a gzip or zstd compressed file
        cat user.json | jd     Read from stdin
+       jd get user.json age   Print the value at 'age'
+       jd set user.json id 7  Set 'id' to the number 7
+       jd export a.json users Print 'users' as CSV
+       jd diff a.json b.json  Compare two files
 
 for more info: https://github.com/tidwall/jd
 `
 )
 
 func main() {
-	var path string
-	if len(os.Args) == 1 {
-		path = "-"
-	} else if len(os.Args) == 2 {
-		if os.Args[1] == "-h" {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "get", "set", "del", "export":
+			os.Exit(command(os.Args[1], os.Args[2:]))
+		case "diff":
+			os.Exit(diff(os.Args[2:]))
+		}
+	}
+	var opts jd.Options
+	var args []string
+	for i := 1; i < len(os.Args); i++ {
+		switch arg := os.Args[i]; {
+		case arg == "-h":
 			fmt.Fprintf(os.Stdout, "%s\n", strings.TrimSpace(usage))
 			return
+		case arg == "-j":
+			opts.Journal = true
+		case arg == "-c":
+			opts.Clipboard = true
+		case arg == "-b":
+			opts.Backup = true
+		case arg == "--schema" && i+1 < len(os.Args):
+			i++
+			opts.Schema = os.Args[i]
+		case strings.HasPrefix(arg, "--schema="):
+			opts.Schema = arg[len("--schema="):]
+		default:
+			args = append(args, arg)
 		}
-		path = os.Args[1]
 	}
-	if err := jd.Exec(path); err != nil {
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	if err := jd.ExecFiles(args, &opts); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// command runs a get, set, del or export command and returns the exit
+// code. Files in other formats than json are converted like in the editor.
+func command(name string, args []string) int {
+	var typ string
+	table := "csv"
+	var rest []string
+	for _, arg := range args {
+		switch arg {
+		case "--raw", "--string":
+			typ = arg
+		case "--csv", "--tsv", "--md":
+			if name != "export" {
+				typ = arg
+			}
+			table = arg[2:]
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	nargs := 2
+	if name == "set" {
+		nargs = 3
+	}
+	if len(rest) != nargs || (typ != "" && name != "set") {
+		fmt.Fprintf(os.Stderr, "%s\n", strings.TrimSpace(usage))
+		return 2
+	}
+	file, path := rest[0], rest[1]
+	b, perm, err := readfile(file)
+	if err != nil {
+		return fail(err)
+	}
+	doc, err := jd.Decode(file, b)
+	if err != nil {
+		return fail(err)
+	}
+	json := doc.JSON()
+	var out []byte
+	switch name {
+	case "get":
+		out, err = jd.Get(json, path)
+		if err != nil {
+			return fail(err)
+		}
+		os.Stdout.Write(append(out, '\n'))
+		return 0
+	case "set":
+		switch typ {
+		case "--raw":
+			out, err = jd.SetRaw(json, path, rest[2])
+		case "--string":
+			out, err = jd.SetString(json, path, rest[2])
+		default:
+			out, err = jd.Set(json, path, rest[2])
+		}
+	case "del":
+		out, err = jd.Delete(json, path)
+	case "export":
+		out, err = jd.Export(json, path, table)
+		if err != nil {
+			return fail(err)
+		}
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err == nil {
+		err = doc.Update(out)
+	}
+	if err == nil {
+		out, err = doc.Encode()
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if file == "-" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = jd.WriteFile(file, out, perm)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// diff opens the diff of two files and returns the exit code.
+func diff(args []string) int {
+	var opts jd.Options
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-u":
+			opts.Unified = true
+		case arg == "-b":
+			opts.Backup = true
+		case arg == "--id" && i+1 < len(args):
+			i++
+			opts.DiffKey = args[i]
+		case strings.HasPrefix(arg, "--id="):
+			opts.DiffKey = arg[len("--id="):]
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 2 {
+		fmt.Fprintf(os.Stderr, "%s\n", strings.TrimSpace(usage))
+		return 2
+	}
+	if err := jd.ExecDiff(files[0], files[1], &opts); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func readfile(file string) ([]byte, os.FileMode, error) {
+	if file == "-" {
+		b, err := ioutil.ReadAll(os.Stdin)
+		return b, 0600, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := ioutil.ReadFile(file)
+	return b, fi.Mode(), err
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "jd: %v\n", err)
+	if err == jd.ErrNotFound {
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,353 @@
+package jd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tidwall/gjson"
+)
+
+// diffview shows the changes between two files, either side by side or
+// unified, and copies changes from one side to the other.
+type diffview struct {
+	sides        [2]*Editor
+	match        string // the key that array elements are matched by
+	unified      bool
+	changes      []change
+	idx          int // the selected change
+	top          int // the first row on screen
+	dirty        [2]bool
+	quitconfirm  bool // exit without writing
+	writeconfirm bool // write over a file that changed on disk
+	msg          string
+	w, h         int
+}
+
+// diffrow is a line of the view. The op is '@' for the path of a change,
+// '-' or '+' for a line of a value in a unified view and ' ' for the
+// lines of both values side by side.
+type diffrow struct {
+	change      int
+	op          byte
+	left, right string
+}
+
+// ExecDiff opens the changes from the file at a to the file at b. Objects
+// are compared by key, so the order of the keys and the whitespace don't
+// matter. A path of "-" reads from stdin.
+func ExecDiff(a, b string, opts *Options) error {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	if a == "-" && b == "-" {
+		return errors.New("stdin can only be opened once")
+	}
+	v := &diffview{match: opts.DiffKey, unified: opts.Unified}
+	for i, path := range []string{a, b} {
+		e, err := openbuffer(path, &Options{Backup: opts.Backup})
+		if err != nil {
+			return err
+		}
+		if e.repairmode {
+			return fmt.Errorf("%s: %s", e.name, e.repairmsg)
+		}
+		v.sides[i] = e
+	}
+	v.rediff()
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+	termbox.SetOutputMode(termbox.Output256)
+	return v.run()
+}
+
+func (v *diffview) rediff() {
+	d := &differ{byindex: v.match == "", key: v.match}
+	d.values("", "", gjson.ParseBytes(v.sides[0].json), gjson.ParseBytes(v.sides[1].json))
+	v.changes = d.out
+	if v.idx >= len(v.changes) {
+		v.idx = len(v.changes) - 1
+	}
+	if v.idx < 0 {
+		v.idx = 0
+	}
+}
+
+func (v *diffview) run() error {
+	v.redraw()
+	for {
+		switch ev := termbox.PollEvent(); ev.Type {
+		case termbox.EventKey:
+			if ev.Ch == 0 && ev.Key == 24 {
+				// Ctrl-X, exit
+				if (v.dirty[0] || v.dirty[1]) && !v.quitconfirm {
+					v.quitconfirm = true
+					v.msg = "not written, ^X again to exit"
+					v.redraw()
+					continue
+				}
+				return nil
+			}
+			v.key(ev)
+		case termbox.EventResize:
+			v.redraw()
+		}
+	}
+}
+
+func (v *diffview) key(ev termbox.Event) {
+	v.msg = ""
+	v.quitconfirm = false
+	if ev.Ch != 0 || ev.Key != 15 {
+		v.writeconfirm = false
+	}
+	switch {
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'n':
+		v.selectchange(v.idx + 1)
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'p':
+		v.selectchange(v.idx - 1)
+	case ev.Key == termbox.KeyPgdn:
+		v.top += v.h / 2
+	case ev.Key == termbox.KeyPgup:
+		v.top -= v.h / 2
+	case ev.Key == termbox.KeyTab:
+		v.unified = !v.unified
+		v.selectchange(v.idx)
+	case ev.Key == termbox.KeyArrowRight || ev.Ch == '>':
+		v.apply(true)
+	case ev.Key == termbox.KeyArrowLeft || ev.Ch == '<':
+		v.apply(false)
+	case ev.Ch == 0 && ev.Key == 15:
+		// Ctrl-O, write the files that were changed
+		v.write()
+	}
+	v.redraw()
+}
+
+// selectchange selects the change at idx and scrolls to it.
+func (v *diffview) selectchange(idx int) {
+	if idx < 0 || idx >= len(v.changes) {
+		return
+	}
+	v.idx = idx
+	for i, row := range v.rows() {
+		if row.change == idx {
+			v.top = i
+			break
+		}
+	}
+}
+
+// apply copies the selected change to the right side, or to the left side
+// when toright is false, so both sides have the same value there.
+func (v *diffview) apply(toright bool) {
+	if len(v.changes) == 0 {
+		return
+	}
+	c := v.changes[v.idx]
+	side, src := 1, 0
+	path, val, insert := c.path, c.old, c.op == '-'
+	if !toright {
+		side, src = 0, 1
+		path, val, insert = c.from, c.new, c.op == '+'
+	}
+	e := v.sides[side]
+	njson, err := patch(e.json, path, v.sides[src].json, val, insert)
+	if err != nil {
+		v.msg = err.Error()
+		return
+	}
+	e.commit(path, njson)
+	v.dirty[side] = true
+	v.rediff()
+	v.selectchange(v.idx)
+}
+
+// write writes the sides that were changed to their files.
+func (v *diffview) write() {
+	var written []string
+	for i, e := range v.sides {
+		if !v.dirty[i] {
+			continue
+		}
+		path := e.stamp.path
+		if path == "" {
+			v.msg = fmt.Sprintf("%s can't be written", e.name)
+			return
+		}
+		if e.stamp.changed() && !v.writeconfirm {
+			v.writeconfirm = true
+			v.msg = fmt.Sprintf("%s changed on disk, ^O again to overwrite", e.name)
+			return
+		}
+		out, err := e.output(path)
+		var raw []byte
+		if err == nil {
+			raw, err = compress(e.codec, out)
+		}
+		if err == nil {
+			err = savefile(path, raw, e.perm, e.backup)
+		}
+		if err != nil {
+			v.msg = err.Error()
+			return
+		}
+		e.stamp = stampfile(path, raw)
+		v.dirty[i] = false
+		written = append(written, e.name)
+	}
+	v.writeconfirm = false
+	if len(written) == 0 {
+		v.msg = "nothing to write"
+		return
+	}
+	v.msg = "wrote " + strings.Join(written, " and ")
+}
+
+// rows returns the lines of the view.
+func (v *diffview) rows() []diffrow {
+	width := v.w
+	if !v.unified {
+		width = v.w/2 - 2
+	}
+	var rows []diffrow
+	for i, c := range v.changes {
+		path := c.path
+		switch {
+		case c.op == '-':
+			path = c.from
+		case c.op == '~' && c.from != c.path:
+			// an array element that moved
+			path = c.from + " → " + c.path
+		}
+		if path == "" {
+			path = "(root)"
+		}
+		rows = append(rows, diffrow{change: i, op: '@', left: string(c.op) + " " + path})
+		left, right := valuelines(c.old, width), valuelines(c.new, width)
+		if v.unified {
+			for _, l := range left {
+				rows = append(rows, diffrow{change: i, op: '-', left: l})
+			}
+			for _, l := range right {
+				rows = append(rows, diffrow{change: i, op: '+', right: l})
+			}
+			continue
+		}
+		for j := 0; j < len(left) || j < len(right); j++ {
+			row := diffrow{change: i, op: ' '}
+			if j < len(left) {
+				row.left = left[j]
+			}
+			if j < len(right) {
+				row.right = right[j]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// valuelines returns the lines of a value pretty printed to fit in width.
+func valuelines(val gjson.Result, width int) []string {
+	if !val.Exists() {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(pretty([]byte(val.Raw), width))), "\n")
+}
+
+func (v *diffview) redraw() {
+	v.w, v.h = termbox.Size()
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	rows := v.rows()
+	vislines := v.h - 3
+	if v.top > len(rows)-vislines {
+		v.top = len(rows) - vislines
+	}
+	if v.top < 0 {
+		v.top = 0
+	}
+	// the names of the files and where the selected change is
+	bar := termbox.Attribute(statusBar)
+	for x := 0; x < v.w; x++ {
+		termbox.SetCell(x, 0, ' ', termbox.ColorWhite, bar)
+	}
+	names := [2]string{"- " + v.sides[0].name, "+ " + v.sides[1].name}
+	for i := range names {
+		if v.dirty[i] {
+			names[i] += " *"
+		}
+	}
+	if v.unified {
+		v.text(0, 0, v.w, names[0]+"  "+names[1], termbox.ColorWhite|termbox.AttrBold, bar)
+	} else {
+		v.text(0, 0, v.w/2, names[0], termbox.ColorWhite|termbox.AttrBold, bar)
+		v.text(v.w/2+1, 0, v.w, names[1], termbox.ColorWhite|termbox.AttrBold, bar)
+	}
+	pos := "no changes"
+	if len(v.changes) > 0 {
+		pos = fmt.Sprintf("change %d of %d", v.idx+1, len(v.changes))
+	}
+	v.text(v.w-len(pos)-1, 0, v.w, pos, termbox.ColorWhite|termbox.AttrBold, bar)
+	for i := 0; i < vislines && v.top+i < len(rows); i++ {
+		row, y := rows[v.top+i], i+1
+		switch row.op {
+		case '@':
+			fg, bg := termbox.Attribute(lightGray)|termbox.AttrBold, termbox.ColorDefault
+			if row.change == v.idx {
+				fg, bg = termbox.ColorBlack, termbox.ColorWhite
+				for x := 0; x < v.w; x++ {
+					termbox.SetCell(x, y, ' ', fg, bg)
+				}
+			}
+			v.text(0, y, v.w, row.left, fg, bg)
+		case '-':
+			v.text(0, y, v.w, "- "+row.left, termbox.ColorRed, termbox.ColorDefault)
+		case '+':
+			v.text(0, y, v.w, "+ "+row.right, termbox.ColorGreen, termbox.ColorDefault)
+		default:
+			v.text(2, y, v.w/2, row.left, termbox.ColorRed, termbox.ColorDefault)
+			termbox.SetCell(v.w/2, y, '│', termbox.Attribute(gray), termbox.ColorDefault)
+			v.text(v.w/2+2, y, v.w, row.right, termbox.ColorGreen, termbox.ColorDefault)
+		}
+	}
+	if v.msg != "" {
+		msg := "[ " + v.msg + " ]"
+		v.text((v.w-len(msg))/2, v.h-2, v.w, msg, termbox.ColorBlack, termbox.ColorWhite)
+	}
+	v.blithelp()
+	termbox.Flush()
+}
+
+// text draws s from x to end on line y.
+func (v *diffview) text(x, y, end int, s string, fg, bg termbox.Attribute) {
+	if x < 0 {
+		x = 0
+	}
+	for _, c := range s {
+		if x >= end {
+			return
+		}
+		termbox.SetCell(x, y, c, fg, bg)
+		x++
+	}
+}
+
+func (v *diffview) blithelp() {
+	x := 0
+	ps := func(h, s string) {
+		v.text(x, v.h-1, v.w, h, termbox.ColorBlack, termbox.ColorWhite)
+		x += len([]rune(h)) + 1
+		v.text(x, v.h-1, v.w, s, termbox.ColorDefault, termbox.ColorDefault)
+		x += len(s) + 3
+	}
+	ps("^X", "Exit")
+	ps("↑↓", "Change")
+	ps(">", "CopyRight")
+	ps("<", "CopyLeft")
+	ps("Tab", "Layout")
+	ps("^O", "WriteOut")
+}
@@ -0,0 +1,107 @@
+package jd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// codec is the compression of a file. The json buffer always holds the
+// decompressed content.
+type codec int
+
+const (
+	codecNone codec = iota
+	codecGzip
+	codecZstd
+)
+
+// codecof returns the codec for the extension of path.
+func codecof(path string) codec {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz", ".gzip":
+		return codecGzip
+	case ".zst", ".zstd":
+		return codecZstd
+	}
+	return codecNone
+}
+
+// trimcodec returns path without the extension of its codec, so that
+// 'user.json.gz' has the format of 'user.json'.
+func trimcodec(path string) string {
+	if codecof(path) == codecNone {
+		return path
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// detectcodec returns the codec of b from its magic bytes.
+func detectcodec(b []byte) codec {
+	switch {
+	case bytes.HasPrefix(b, []byte{0x1f, 0x8b}):
+		return codecGzip
+	case bytes.HasPrefix(b, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return codecZstd
+	}
+	return codecNone
+}
+
+// decompress returns b decompressed when it starts with the magic bytes of
+// a codec, or else b as it is.
+func decompress(b []byte) ([]byte, error) {
+	switch detectcodec(b) {
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case codecZstd:
+		d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		return d.DecodeAll(b, nil)
+	}
+	return b, nil
+}
+
+// compress returns b compressed with c.
+func compress(c codec, b []byte) ([]byte, error) {
+	switch c {
+	case codecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case codecZstd:
+		w, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer w.Close()
+		return w.EncodeAll(b, nil), nil
+	}
+	return b, nil
+}
+
+// codecfor returns the codec of the file written to path, which is the one
+// of the loaded file when path is that file, or else the one of its
+// extension.
+func (e *Editor) codecfor(path string) codec {
+	if e.stamp.path != "" && samefile(path, e.stamp.path) {
+		return e.codec
+	}
+	return codecof(path)
+}
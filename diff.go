@@ -0,0 +1,412 @@
+package jd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// maxDiffEdits is the most edits that linediff looks for. Inputs that
+// differ more are shown as the whole of one replaced by the other.
+const maxDiffEdits = 4096
+
+// diffline is a line of a diff. The op is ' ' for a line that's in both,
+// '-' for a line only in the first, '+' for a line only in the second and
+// '@' for a run of lines that were left out.
+type diffline struct {
+	op   byte
+	text string
+}
+
+// linediff returns the lines that turn a into b.
+func linediff(a, b []string) []diffline {
+	var pre, suf int
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var d []diffline
+	for _, s := range a[:pre] {
+		d = append(d, diffline{' ', s})
+	}
+	d = append(d, myers(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, s := range a[len(a)-suf:] {
+		d = append(d, diffline{' ', s})
+	}
+	return d
+}
+
+// myers is the O(ND) diff of Myers. Each step keeps the furthest reaching
+// paths of its diagonals, which is walked back to find the edits.
+func myers(a, b []string) []diffline {
+	n, m := len(a), len(b)
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			var r []diffline
+			for _, s := range a {
+				r = append(r, diffline{'-', s})
+			}
+			for _, s := range b {
+				r = append(r, diffline{'+', s})
+			}
+			return r
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+		// the diagonals from -d to d
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+	return nil
+}
+
+func backtrack(a, b []string, trace [][]int) []diffline {
+	var r []diffline
+	x, y := len(a), len(b)
+	for d := len(trace); d > 0; d-- {
+		prev := trace[d-1] // the diagonals from -(d-1) to d-1
+		k := x - y
+		pk := k - 1
+		if k == -d || k != d && prev[k-1+d-1] < prev[k+1+d-1] {
+			pk = k + 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+		for x > px && y > py {
+			r = append(r, diffline{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == px {
+			r = append(r, diffline{'+', b[y-1]})
+			y--
+		} else {
+			r = append(r, diffline{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 {
+		r = append(r, diffline{' ', a[x-1]})
+		x--
+	}
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return r
+}
+
+// hunks leaves out the lines of d that are further than ctx lines from a
+// change. It returns nil when nothing changed.
+func hunks(d []diffline, ctx int) []diffline {
+	near := make([]bool, len(d))
+	var changed bool
+	for i, l := range d {
+		if l.op == ' ' {
+			continue
+		}
+		changed = true
+		for j := i - ctx; j <= i+ctx; j++ {
+			if j >= 0 && j < len(d) {
+				near[j] = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	var r []diffline
+	for i := 0; i < len(d); {
+		if near[i] {
+			r = append(r, d[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(d) && !near[j] {
+			j++
+		}
+		if j-i == 1 {
+			// the line takes as much room as saying it was left out
+			r = append(r, d[i])
+		} else {
+			r = append(r, diffline{'@', fmt.Sprintf("%d unchanged lines", j-i)})
+		}
+		i = j
+	}
+	return r
+}
+
+// jsondiff returns the changes between the pretty printed json of a and b,
+// with ctx lines around each change.
+func jsondiff(a, b []byte, ctx int) []diffline {
+	la := strings.Split(strings.TrimSpace(string(prettyindent(a, "  "))), "\n")
+	lb := strings.Split(strings.TrimSpace(string(prettyindent(b, "  "))), "\n")
+	return hunks(linediff(la, lb), ctx)
+}
+
+// blitdiff draws the lines of a diff from the line top in place of the
+// result.
+func (e *Editor) blitdiff(lines []diffline, top int) {
+	e.resy = e.y
+	vislines := e.h - e.resy - 2
+	for i := 0; i < vislines && top+i < len(lines); i++ {
+		l := lines[top+i]
+		fg := termbox.Attribute(lightGray)
+		switch l.op {
+		case '-':
+			fg = termbox.ColorRed
+		case '+':
+			fg = termbox.ColorGreen
+		case '@':
+			fg = termbox.Attribute(gray)
+		}
+		text := []rune(string(l.op) + " " + l.text)
+		if l.op == '@' {
+			text = []rune("@@ " + l.text + " @@")
+		}
+		for x := 0; x < e.w && x < len(text); x++ {
+			termbox.SetCell(x, e.resy+i, text[x], fg, termbox.ColorDefault)
+		}
+	}
+}
+
+// change is a difference between the json a and b. The op is '+' for a
+// value that's only in b, '-' for a value that's only in a and '~' for a
+// value that's different.
+type change struct {
+	op   byte
+	from string       // the path in a, where a value of b goes in
+	path string       // the path in b, where a value of a goes in
+	old  gjson.Result // the value in a, with the Index in a
+	new  gjson.Result // the value in b, with the Index in b
+}
+
+// differ finds the changes between two documents. The members of objects
+// are matched by key. The elements of arrays are matched by the longest
+// run of elements that are the same, so an element that was inserted
+// doesn't show every element after it as changed, or else by index, or by
+// the value of a key of the objects in them, wherever they are.
+type differ struct {
+	byindex bool
+	key     string
+	out     []change
+}
+
+// changes returns the changes from the json a to b.
+func changes(a, b []byte) []change {
+	d := &differ{}
+	d.values("", "", gjson.ParseBytes(a), gjson.ParseBytes(b))
+	return d.out
+}
+
+func (d *differ) values(from, path string, a, b gjson.Result) {
+	if a.Raw == b.Raw || jsonequal(a, b) {
+		return
+	}
+	switch {
+	case isobject(a) && isobject(b):
+		has := make(map[string]bool)
+		a.ForEach(func(key, av gjson.Result) bool {
+			name := key.String()
+			has[name] = true
+			av.Index += a.Index
+			if bv := b.Get(joinpath("", name)); bv.Exists() {
+				bv.Index += b.Index
+				d.values(joinpath(from, name), joinpath(path, name), av, bv)
+			} else {
+				d.out = append(d.out, change{op: '-', from: joinpath(from, name), path: joinpath(path, name), old: av})
+			}
+			return true
+		})
+		b.ForEach(func(key, bv gjson.Result) bool {
+			if name := key.String(); !has[name] {
+				bv.Index += b.Index
+				d.out = append(d.out, change{op: '+', from: joinpath(from, name), path: joinpath(path, name), new: bv})
+			}
+			return true
+		})
+	case isarray(a) && isarray(b):
+		d.arrays(from, path, a, b)
+	default:
+		d.out = append(d.out, change{op: '~', from: from, path: path, old: a, new: b})
+	}
+}
+
+func (d *differ) arrays(from, path string, a, b gjson.Result) {
+	ea, eb := elements(a), elements(b)
+	if d.key != "" {
+		d.arraysbykey(from, path, ea, eb)
+		return
+	}
+	var ops []diffline
+	if d.byindex {
+		for i := 0; i < len(ea) || i < len(eb); i++ {
+			switch {
+			case i >= len(eb):
+				ops = append(ops, diffline{op: '-'})
+			case i >= len(ea):
+				ops = append(ops, diffline{op: '+'})
+			default:
+				ops = append(ops, diffline{op: ' '})
+			}
+		}
+	} else {
+		ops = linediff(canonical(ea), canonical(eb))
+	}
+	var i, j int
+	for k := 0; k < len(ops); {
+		if ops[k].op == ' ' {
+			// matched, so what changed inside is looked for
+			d.values(joinpath(from, strconv.Itoa(i)), joinpath(path, strconv.Itoa(j)), ea[i], eb[j])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+		// a run of removed and added elements, where the first ones of each
+		// are taken as changed
+		var dels, adds int
+		for ; k < len(ops) && ops[k].op != ' '; k++ {
+			if ops[k].op == '-' {
+				dels++
+			} else {
+				adds++
+			}
+		}
+		n := 0
+		for ; n < dels && n < adds; n++ {
+			d.values(joinpath(from, strconv.Itoa(i+n)), joinpath(path, strconv.Itoa(j+n)), ea[i+n], eb[j+n])
+		}
+		for t := n; t < dels; t++ {
+			d.out = append(d.out, change{op: '-', from: joinpath(from, strconv.Itoa(i+t)),
+				path: joinpath(path, strconv.Itoa(j+n)), old: ea[i+t]})
+		}
+		for t := n; t < adds; t++ {
+			d.out = append(d.out, change{op: '+', from: joinpath(from, strconv.Itoa(i+n)),
+				path: joinpath(path, strconv.Itoa(j+t)), new: eb[j+t]})
+		}
+		i, j = i+dels, j+adds
+	}
+}
+
+// arraysbykey pairs the objects of two arrays that have the same value of
+// the key, wherever they are in the arrays. The elements that aren't
+// objects with the key are paired when they're the same. Only elements
+// that have no partner are removed or added.
+func (d *differ) arraysbykey(from, path string, ea, eb []gjson.Result) {
+	idx := make(map[string][]int) // the unpaired elements of b by id
+	for j, el := range eb {
+		id := d.id(el)
+		idx[id] = append(idx[id], j)
+	}
+	pa := make([]int, len(ea)) // the partner in b of each element of a
+	pb := make([]int, len(eb))
+	for j := range pb {
+		pb[j] = -1
+	}
+	for i, el := range ea {
+		pa[i] = -1
+		id := d.id(el)
+		if js := idx[id]; len(js) > 0 {
+			pa[i], pb[js[0]] = js[0], i
+			idx[id] = js[1:]
+		}
+	}
+	// an element without a partner goes in after the partner of the element
+	// before it
+	var at int
+	for i, el := range ea {
+		if pa[i] != -1 {
+			d.values(joinpath(from, strconv.Itoa(i)), joinpath(path, strconv.Itoa(pa[i])), el, eb[pa[i]])
+			at = pa[i] + 1
+			continue
+		}
+		d.out = append(d.out, change{op: '-', from: joinpath(from, strconv.Itoa(i)),
+			path: joinpath(path, strconv.Itoa(at)), old: el})
+	}
+	at = 0
+	for j, el := range eb {
+		if pb[j] != -1 {
+			at = pb[j] + 1
+			continue
+		}
+		d.out = append(d.out, change{op: '+', from: joinpath(from, strconv.Itoa(at)),
+			path: joinpath(path, strconv.Itoa(j)), new: el})
+	}
+}
+
+// id returns what an array element is matched by when the elements are
+// matched by key.
+func (d *differ) id(el gjson.Result) string {
+	if id := el.Get(joinpath("", d.key)); isobject(el) && id.Exists() {
+		return "id:" + string(appendsorted(nil, id))
+	}
+	return string(appendsorted(nil, el))
+}
+
+// elements returns the elements of an array, with the Index in the
+// document of the array.
+func elements(arr gjson.Result) []gjson.Result {
+	var els []gjson.Result
+	arr.ForEach(func(_, v gjson.Result) bool {
+		v.Index += arr.Index
+		els = append(els, v)
+		return true
+	})
+	return els
+}
+
+// canonical returns the elements as strings that are the same for the
+// same json.
+func canonical(els []gjson.Result) []string {
+	var ss []string
+	for _, el := range els {
+		ss = append(ss, string(appendsorted(nil, el)))
+	}
+	return ss
+}
+
+// patch puts the value val of the json src at path, or deletes the value
+// at path when val doesn't exist. With insert, an array element goes in
+// before the element at path and an object member goes after the member
+// that it follows in src.
+func patch(json []byte, path string, src []byte, val gjson.Result, insert bool) ([]byte, error) {
+	if !val.Exists() {
+		if path == "" {
+			return []byte{}, nil
+		}
+		return sjson.DeleteBytes(json, path)
+	}
+	raw := dedent(src, val.Index, []byte(val.Raw))
+	if insert {
+		if _, ok := locateel(json, path); ok {
+			njson, _, err := insertel(json, path, raw, false)
+			return njson, err
+		}
+		if njson, ok := insertkey(json, path, src, raw); ok {
+			return njson, nil
+		}
+	}
+	return setraw(json, path, raw)
+}
@@ -0,0 +1,181 @@
+package jd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// changelist returns the changes as strings like "~ a: 1 → 2".
+func changelist(cs []change) []string {
+	var out []string
+	for _, c := range cs {
+		switch c.op {
+		case '+':
+			out = append(out, fmt.Sprintf("+ %s: %s", c.path, c.new.Raw))
+		case '-':
+			out = append(out, fmt.Sprintf("- %s: %s", c.from, c.old.Raw))
+		default:
+			path := c.path
+			if c.from != c.path {
+				path = c.from + " → " + c.path
+			}
+			out = append(out, fmt.Sprintf("~ %s: %s → %s", path, c.old.Raw, c.new.Raw))
+		}
+	}
+	return out
+}
+
+func TestDiffByKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []string
+	}{
+		{`[{"id":1,"v":"a"}]`, `[{"id":2,"v":"a"}]`,
+			[]string{`- 0: {"id":1,"v":"a"}`, `+ 0: {"id":2,"v":"a"}`}},
+		{`[{"id":1,"v":"a"},{"id":2}]`, `[{"id":2},{"id":1,"v":"X"}]`,
+			[]string{`~ 0.v → 1.v: "a" → "X"`}},
+		{`[{"id":1},{"id":2},{"id":3}]`, `[{"id":1},{"id":3}]`,
+			[]string{`- 1: {"id":2}`}},
+		{`[{"id":1},"x",{"id":2}]`, `[{"id":2},"x",{"id":4}]`,
+			[]string{`- 0: {"id":1}`, `+ 2: {"id":4}`}},
+		{`[{"id":1},{"v":1}]`, `[{"v":2},{"id":1}]`,
+			[]string{`- 1: {"v":1}`, `+ 0: {"v":2}`}},
+	}
+	for _, tt := range tests {
+		d := &differ{key: "id"}
+		d.values("", "", gjson.Parse(tt.a), gjson.Parse(tt.b))
+		if got := changelist(d.out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s → %s\ngot  %s\nwant %s", tt.a, tt.b,
+				strings.Join(got, "; "), strings.Join(tt.want, "; "))
+		}
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"a b c", "a b c", " a,  b,  c"},
+		{"a b c", "a x c", " a, -b, +x,  c"},
+		{"a b c", "a c", " a, -b,  c"},
+		{"a c", "a b c", " a, +b,  c"},
+		{"", "a b", "+a, +b"},
+		{"a b c d", "b c e", "-a,  b,  c, -d, +e"},
+	}
+	for _, tt := range tests {
+		var lines []string
+		for _, l := range linediff(strings.Fields(tt.a), strings.Fields(tt.b)) {
+			lines = append(lines, string(l.op)+l.text)
+		}
+		if got := strings.Join(lines, ", "); got != tt.want {
+			t.Errorf("%q → %q: got %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		byindex bool
+		want    []string
+	}{
+		{`{"a":1,"b":2}`, `{"b":2.0,"a":1}`, false, nil},
+		{`{"a":1,"b":[1]}`, `{"a":2,"c":3}`, false,
+			[]string{`~ a: 1 → 2`, `- b: [1]`, `+ c: 3`}},
+		{`1`, `"1"`, false, []string{`~ : 1 → "1"`}},
+		// an element that's inserted doesn't change the ones after it
+		{`[1,2,3]`, `[0,1,2,3]`, false, []string{`+ 0: 0`}},
+		{`[1,2,3]`, `[1,2,9,3]`, false, []string{`+ 2: 9`}},
+		{`[1,2,3]`, `[1,3]`, false, []string{`- 1: 2`}},
+		{`[{"a":1},2]`, `[{"a":5},2]`, false, []string{`~ 0.a: 1 → 5`}},
+		{`[1,2,3]`, `[1,8,9,3]`, false, []string{`~ 1: 2 → 8`, `+ 2: 9`}},
+		{`[1,2,3]`, `[0,1,2,3]`, true, []string{`~ 0: 1 → 0`, `~ 1: 2 → 1`, `~ 2: 3 → 2`, `+ 3: 3`}},
+		{`[1,2,3]`, `[1,2]`, true, []string{`- 2: 3`}},
+	}
+	for _, tt := range tests {
+		d := &differ{byindex: tt.byindex}
+		d.values("", "", gjson.Parse(tt.a), gjson.Parse(tt.b))
+		if got := changelist(d.out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s → %s\ngot  %s\nwant %s", tt.a, tt.b,
+				strings.Join(got, "; "), strings.Join(tt.want, "; "))
+		}
+	}
+}
+
+// TestPatch copies the first change from b to a until there are none left,
+// which is what copying every change to the left side of a diff does.
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		a, b string
+		key  string
+	}{
+		{`{"a":1,"b":2}`, `{"a":3,"c":{"d":[1]}}`, ""},
+		{`[1,2,3]`, `[0,1,2,9,3]`, ""},
+		{`[1,2,3,4]`, `[2,4]`, ""},
+		{"[\n  1,\n  2\n]", "[\n  1,\n  {\n    \"x\": [\n      5\n    ]\n  },\n  2\n]", ""},
+		{`{"l":[{"id":1},{"id":2,"v":1}]}`, `{"l":[{"id":3},{"id":2,"v":2},{"id":1}]}`, "id"},
+		{`[{"id":1},{"id":2}]`, `[]`, "id"},
+		{`[]`, `[{"id":1},{"id":2}]`, "id"},
+		{`1`, `{"a":1}`, ""},
+	}
+	for _, tt := range tests {
+		a, b := []byte(tt.a), []byte(tt.b)
+		for n := 0; ; n++ {
+			if n == 20 {
+				t.Fatalf("%s → %s: still at %s", tt.a, tt.b, a)
+			}
+			d := &differ{key: tt.key}
+			d.values("", "", gjson.ParseBytes(a), gjson.ParseBytes(b))
+			if len(d.out) == 0 {
+				break
+			}
+			c := d.out[0]
+			na, err := patch(a, c.from, b, c.new, c.op == '+')
+			if err != nil {
+				t.Fatalf("%s → %s: %v", tt.a, tt.b, err)
+			}
+			if !valid(string(na)) {
+				t.Fatalf("%s → %s: not valid json %s", tt.a, tt.b, na)
+			}
+			a = na
+		}
+		// matched by key, the order of the elements isn't a change
+		if tt.key == "" && !jsonequal(gjson.ParseBytes(a), gjson.ParseBytes(b)) {
+			t.Errorf("%s → %s: got %s", tt.a, tt.b, a)
+		}
+	}
+}
+
+func TestPatchKeyOrder(t *testing.T) {
+	tests := []struct {
+		json, src, path string
+		want            string
+	}{
+		{`{"a":1,"c":3}`, `{"a":1,"b":2,"c":3}`, "b", `{"a":1,"b":2,"c":3}`},
+		{`{"b":2,"c":3}`, `{"a":1,"b":2,"c":3}`, "a", `{"a":1,"b":2,"c":3}`},
+		{`{"a":1,"b":2}`, `{"a":1,"b":2,"c":3}`, "c", `{"a":1,"b":2,"c":3}`},
+		{`{"c":3,"a":1}`, `{"a":1,"x":0,"b":2}`, "b", `{"c":3,"a":1,"b":2}`},
+		{`{"a": 1, "c": 3}`, `{"a": 1, "b": 2, "c": 3}`, "b", `{"a": 1, "b": 2, "c": 3}`},
+		{"{\n  \"a\": 1,\n  \"c\": 3\n}", "{\n  \"a\": 1,\n  \"b\": {\n    \"x\": 2\n  },\n  \"c\": 3\n}", "b",
+			"{\n  \"a\": 1,\n  \"b\": {\n    \"x\": 2\n  },\n  \"c\": 3\n}"},
+		{"{\n  \"o\": {\n    \"b\": 2\n  }\n}", "{\n  \"o\": {\n    \"a\": [\n      1\n    ],\n    \"b\": 2\n  }\n}", "o.a",
+			"{\n  \"o\": {\n    \"a\": [\n      1\n    ],\n    \"b\": 2\n  }\n}"},
+		{`{}`, `{"a":1}`, "a", `{"a":1}`},
+	}
+	for _, tt := range tests {
+		val, _ := locate([]byte(tt.src), tt.path)
+		got, err := patch([]byte(tt.json), tt.path, []byte(tt.src), val, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.json, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s + %s:\ngot  %s\nwant %s", tt.json, tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package jd
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -23,7 +24,6 @@ const (
 	statusBar = gray
 	editBar   = gray //53
 )
-const maxUndos = 10
 
 type Editor struct {
 	editdirty    bool
@@ -46,6 +46,15 @@ type Editor struct {
 	parts        []string
 	esc          bool
 	editmode     bool
+	renamemode   bool
+	insertmode   bool
+	insertafter  bool
+	edittype     valuetype
+	panemode     bool
+	panelines    [][]rune
+	panex, paney int // pane cursor position
+	panescrollx  int
+	panescrolly  int
 	fg, bg       termbox.Attribute
 	vpathels     map[string]gjson.Result
 	statusy      int
@@ -58,18 +67,91 @@ type Editor struct {
 	cx, cy       int // cursor position
 	scrolly      int // the ideal scroll position
 	resy         int
-	undos        []Editor
+	undos        []undoop
 	undoidx      int
+	undosize     int
 	writeval     string
 	widx         int
 	perm         os.FileMode
 	writeerr     error
 	writets      time.Time
+	journal      *journal
+	sess         *session
+	name         string // the name of the buffer
+	listmode     bool   // showing the buffer list
+	listidx      int
+	savedidx     int  // the undo index of the content on disk
+	nextreg      rune // register for the next copy, cut or paste
+	selectreg    bool // the next key chooses a register
+	osc52        bool
+	bulkmode     bool
+	bulkpath     string         // the path that is being bulk edited
+	bulkpaths    []string       // every location that bulkpath matches
+	bulkres      []gjson.Result // the matches in the formatted json
+	stamp        filestamp      // the loaded file
+	backup       bool
+	writeconfirm bool // the file changed on disk, confirm to overwrite
+	promptmode   int  // what the bottom prompt asks for
+	promptval    string
+	format       format     // the format of the loaded file
+	lost         []string   // what the conversion from the format loses
+	lossconfirm  bool       // confirm to write without what's lost
+	recview      bool       // showing a single record of the ndjson
+	record       int        // the record being shown
+	jsonc        *jsonc     // the comments of a JSONC file
+	view         string     // the JSONC file text that is shown
+	lazy         *lazyview  // the index of a buffer in large-file mode
+	style        writestyle // the layout of json files on write
+	codec        codec      // the compression of the loaded file
+	repairmode   bool       // fixing json that can't be parsed
+	repairmsg    string     // the parse error or what was fixed
+	disk         filestamp  // the file as it was last seen on disk
+	reloadmode   bool       // the file changed on disk during edits
+	reloaddata   []byte     // the content of the changed file
+	reloaddiff   []diffline // the changes on disk that are shown
+	diffscroll   int
+	schema       *schema     // the JSON Schema of the buffer
+	schemaerrs   []schemaerr // where the formatted json doesn't match it
+	validconfirm bool        // confirm to write json that doesn't match
+	suggests     []string    // the values the schema has for the edit bar
+	suggestidx   int
+	base         []byte   // the json as it was loaded or last written
+	reviewmode   bool     // the changes are shown before writing
+	review       []change // the changes from base
+	reviewidx    int
+	reviewed     bool
 }
 
+// Options are the options for ExecOptions.
+type Options struct {
+	// Journal keeps the undo history in a journal file next to the
+	// edited file, so it survives quitting and unsaved edits can be
+	// recovered after a crash.
+	Journal bool
+	// Clipboard also sends copied values to the system clipboard using
+	// the OSC 52 terminal escape sequence.
+	Clipboard bool
+	// Backup keeps the previous content of a file in a '.bak' file when
+	// writing over it.
+	Backup bool
+	// Schema is the file of a JSON Schema that the files are validated
+	// against. Without it, the '$schema' key of a file is used when it
+	// names a local file.
+	Schema string
+	// DiffKey is the key that the objects in arrays are matched by in a
+	// diff, instead of their index.
+	DiffKey string
+	// Unified shows a diff as one column instead of side by side.
+	Unified bool
+}
+
+// DefaultOptions are the default options for Exec.
+var DefaultOptions = &Options{}
+
 type hintkey struct {
 	key gjson.Result
 	val gjson.Result
+	new bool // allowed by the schema, but not in the document
 }
 type hintbykey []hintkey
 
@@ -83,7 +165,46 @@ func (arr hintbykey) Less(a, b int) bool {
 func (arr hintbykey) Swap(a, b int) {
 	arr[a], arr[b] = arr[b], arr[a]
 }
+
+// Exec opens the file at path in the editor. A path of "-" reads from stdin.
 func Exec(path string) error {
+	return ExecOptions(path, DefaultOptions)
+}
+
+// ExecOptions is like Exec but with options.
+func ExecOptions(path string, opts *Options) error {
+	return ExecFiles([]string{path}, opts)
+}
+
+// ExecFiles opens each file in its own buffer. A path of "-" reads from
+// stdin.
+func ExecFiles(paths []string, opts *Options) error {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	var stdin int
+	for _, path := range paths {
+		if path == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		return errors.New("stdin can only be opened once")
+	}
+	s := &session{registers: make(map[rune]clip)}
+	defer s.close()
+	for _, path := range paths {
+		e, err := openbuffer(path, opts)
+		if err != nil {
+			return err
+		}
+		e.sess = s
+		s.bufs = append(s.bufs, e)
+	}
+	return s.run()
+}
+
+func openbuffer(path string, opts *Options) (*Editor, error) {
 	var b []byte
 	var perm os.FileMode = 0600
 	var fpath string
@@ -91,22 +212,22 @@ func Exec(path string) error {
 		var err error
 		b, err = ioutil.ReadAll(os.Stdin)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else if path != "" {
 		f, err := os.Open(path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer f.Close()
 		fs, err := f.Stat()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		perm = fs.Mode()
 		b, err = ioutil.ReadAll(f)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		f.Close()
 		fpath = path
@@ -116,31 +237,110 @@ func Exec(path string) error {
 		vpathels: make(map[string]gjson.Result),
 		perm:     perm,
 		writeval: fpath,
+		osc52:    opts.Clipboard,
+		backup:   opts.Backup,
+		name:     path,
+	}
+	if path == "-" {
+		e.name = "stdin"
+	}
+	if fpath != "" {
+		e.stamp = stampfile(fpath, b)
+	}
+	if err := e.load(fpath, b); err != nil {
+		return nil, fmt.Errorf("%s: %v", e.name, err)
+	}
+	e.recview = e.format == formatNDJSON
+	// the journal only applies to json that can be parsed
+	broken := e.startrepair()
+	if opts.Journal && fpath != "" && !broken {
+		j, recovered, err := openjournal(e, fpath)
+		if err != nil {
+			return nil, err
+		}
+		e.journal = j
+		if recovered {
+			e.writeerr = errors.New("recovered unsaved edits, ^Z to undo")
+			e.writets = time.Now()
+		}
+	}
+	if err := e.openschema(opts.Schema); err != nil {
+		if opts.Schema != "" {
+			return nil, err
+		}
+		e.seterr(err)
 	}
-	return e.runloop()
+	return e, nil
 }
 
-func (e *Editor) reflow() {
-	var w int
+// decodefile returns the json of the content b of the file at path, with
+// the comments of a JSONC file and what the conversion from its format
+// loses. Compressed content is decompressed first.
+func decodefile(path string, b []byte) (f format, json []byte, c *jsonc, lost []string, err error) {
+	b, err = decompress(b)
+	if err != nil {
+		return f, nil, nil, nil, err
+	}
+	f = detectformat(path, b)
+	json = b
+	if f == formatJSONC {
+		json, c, err = jsonctojson(b)
+	} else if f != formatJSON {
+		json, lost, err = decode(f, b)
+	}
+	return f, json, c, lost, err
+}
 
-	if len(e.json) > 1*1024*1024 {
-		e.resetcolors()
-		termbox.Clear(e.bg, e.fg)
-		e.x, e.y = 0, 0
-		e.blitstr(fmt.Sprintf("loading %d MB buffer", len(e.json)/1024/1024))
-		e.newline()
-		e.blitstr("please wait...")
-		termbox.Flush()
+// load replaces the buffer with the content b of the file at path. The
+// buffer is left as it was when b can't be decoded.
+func (e *Editor) load(path string, b []byte) error {
+	f, json, c, lost, err := decodefile(path, b)
+	if err != nil {
+		return err
+	}
+	e.format, e.json, e.jsonc, e.lost = f, json, c, lost
+	e.base = json
+	e.codec = detectcodec(b)
+	e.style = writestyle{layout: stylePretty, indent: "  ", newline: true}
+	if f == formatJSON {
+		e.style = detectstyle(json)
 	}
+	if len(lost) > 0 {
+		e.seterr(fmt.Errorf("writing drops the %s", strings.Join(lost, ", ")))
+	}
+	return nil
+}
 
+func (e *Editor) reflow() {
+	var w int
 	w, e.h = termbox.Size()
 	if w != e.w || e.editdirty {
 		e.w = w
-		pjson := pretty(e.json, e.w)
-		e.root = gjson.Parse(string(pjson))
+		pjson := e.json
+		if e.recview {
+			pjson = e.recordraw()
+		}
+		if len(pjson) > largeSize && e.jsonc == nil {
+			e.reflowlarge(pjson)
+		} else {
+			if len(pjson) > largeSize {
+				e.blitloading(len(pjson))
+			}
+			if e.jsonc == nil {
+				pjson = pretty(pjson, e.w)
+			} else {
+				e.view = string(e.jsonc.source(pjson))
+			}
+			e.root = gjson.Parse(string(pjson))
+			e.lazy = nil
+			e.countjsonlines()
+		}
 		e.vpathels = make(map[string]gjson.Result)
-		e.countjsonlines()
 		e.editdirty = false
+		e.validate()
+		if e.bulkmode {
+			e.bulkmatch()
+		}
 	}
 	e.exec()
 	e.redraw()
@@ -151,8 +351,9 @@ func (e *Editor) countjsonlines() {
 	var i int
 	var x int
 	e.jsonlines = append(e.jsonlines, 0)
-	for ; i < len(e.root.Raw); i++ {
-		if e.root.Raw[i] == '\n' {
+	view := e.blitroot(0, len(e.root.Raw))
+	for ; i < len(view); i++ {
+		if view[i] == '\n' {
 			x = 0
 			e.jsonlines = append(e.jsonlines, i+1)
 			continue
@@ -165,6 +366,27 @@ func (e *Editor) countjsonlines() {
 	}
 }
 
+// blitroot returns the text that is shown for the bytes from x to y of the
+// formatted json, which is the file text with its comments for JSONC.
+func (e *Editor) blitroot(x, y int) string {
+	if e.jsonc == nil {
+		return e.root.Raw[x:y]
+	}
+	return e.view[e.viewpos(x):e.viewpos(y)]
+}
+
+// viewpos returns the position in the JSONC file text of position i of the
+// formatted json.
+func (e *Editor) viewpos(i int) int {
+	switch i {
+	case 0:
+		return 0
+	case len(e.root.Raw):
+		return len(e.view)
+	}
+	return e.jsonc.srcpos(i + len(e.json) - len(e.root.Raw))
+}
+
 func (e *Editor) blitstr(s string) {
 	for _, c := range s {
 		if c == '\n' {
@@ -195,13 +417,45 @@ func (e *Editor) redraw() {
 	e.blitpath()
 	e.blitstatus()
 	e.topbarsdrawn = true
-	e.blitres()
+	if e.listmode {
+		e.blitlist()
+	} else if e.panemode {
+		e.blitpane()
+	} else if e.reviewmode {
+		e.blitreview()
+	} else if e.reloaddiff != nil {
+		e.blitdiff(e.reloaddiff, e.diffscroll)
+	} else if e.bulkmode {
+		e.blitbulk()
+	} else {
+		e.blitres()
+	}
 	e.blitdebug()
 	e.blithelp()
+	if e.reviewmode {
+		e.blitnotice(e.reviewnotice())
+	} else if e.reloadmode {
+		e.blitnotice(e.reloadnotice())
+	} else if e.repairmode {
+		e.blitnotice(e.repairmsg)
+	}
 	e.bliterr()
 	e.blitcursor()
 	termbox.Flush()
 }
+
+// blitnotice draws a notice that stays on the prompt line.
+func (e *Editor) blitnotice(notice string) {
+	for x := 0; x < e.w; x++ {
+		termbox.SetCell(x, e.h-2, ' ', termbox.ColorBlack, termbox.ColorWhite)
+	}
+	for x, c := range []rune(notice) {
+		if x < e.w {
+			termbox.SetCell(x, e.h-2, c, termbox.ColorBlack, termbox.ColorWhite)
+		}
+	}
+}
+
 func (e *Editor) bliterr() {
 	if e.writets.IsZero() || e.writeerr == nil || time.Now().Sub(e.writets) > time.Second*3 {
 		e.writeerr = nil
@@ -253,18 +507,91 @@ func (e *Editor) blithelp() {
 	for x := 0; x < e.w; x++ {
 		termbox.SetCell(x, e.h-1, ' ', termbox.ColorDefault, termbox.ColorDefault)
 	}
-	if e.writemode {
+	defer e.blitbufname()
+	if e.reviewmode {
+		ps("Enter", "Write")
+		ps("^D", "Drop")
+		ps("^C", "Cancel")
+	} else if e.writemode {
+		ps("^C", "Cancel")
+		if e.promptmode == promptWrite && e.styled(e.writeval) {
+			ps("^F", "Format")
+			ps("^S", "SortKeys")
+			ps("^N", "Newline")
+		}
+	} else if e.listmode {
+		ps("Enter", "Open")
+		ps("Esc", "Close")
+	} else if e.repairmode {
+		ps("^S", "Apply")
+		ps("^F", "AutoFix")
+		ps("^X", "Exit")
+	} else if e.panemode {
+		ps("^S", "Apply")
 		ps("^C", "Cancel")
+	} else if e.reloadmode {
+		ps("R", "Reload")
+		ps("K", "Keep")
+		ps("D", "Diff")
+	} else if e.bulkmode && !e.editmode {
+		ps("^E", "EditAll")
+		ps("^D", "DeleteAll")
+		ps("^F", "Done")
+	} else if e.editmode {
+		ps("Enter", "Save")
+		if !e.renamemode {
+			ps("Tab", "Type")
+		}
+		if len(e.suggests) > 0 && !e.renamemode {
+			ps("↑↓", "Values")
+		}
+		ps("Esc", "Cancel")
 	} else {
 		ps("^X", "Exit")
 		ps("^E", "Edit")
 		ps("^D", "Delete")
+		ps("^R", "Rename")
+		ps("^C", "Copy")
+		ps("^W", "Cut")
+		ps("^V", "Paste")
 		ps("^O", "WriteOut")
 		ps("^Z", "Undo")
+		ps("^Y", "Redo")
+		if len(e.sess.bufs) > 1 {
+			ps("^N", "NextBuffer")
+			ps("^L", "Buffers")
+		}
+		if e.format == formatNDJSON {
+			ps("^T", "Record")
+		}
+		if len(e.schemaerrs) > 0 {
+			ps("^Q", "NextError")
+		}
+		if !e.invalid && len(e.parts) > 0 && isindex(e.parts[len(e.parts)-1]) {
+			ps("^A", "InsertAfter")
+			ps("^B", "InsertBefore")
+			ps("^U", "Duplicate")
+			ps("^K", "MoveUp")
+			ps("^J", "MoveDown")
+		}
+	}
+}
+func isindex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
 	}
+	return true
 }
+
 func (e *Editor) blitcursor() {
-	if e.editmode {
+	if e.panemode {
+		termbox.SetCursor(e.panex-e.panescrollx, e.resy+e.paney-e.panescrolly)
+	} else if e.editmode {
 		y := e.eidx/e.w + e.statusy + 1
 		x := e.eidx % e.w
 		termbox.SetCursor(x, y)
@@ -310,16 +637,58 @@ func (e *Editor) scrollintoview(pos, count int) {
 	defer func() {
 		e.y = e.resy - e.scrolly
 	}()
+	if e.jsonc != nil {
+		s := e.viewpos(pos)
+		pos, count = s, e.viewpos(pos+count)-s
+	}
 	vislines := e.h - e.resy - 1
 	if vislines < 1 {
 		vislines = 1
 	}
-	if len(e.jsonlines) <= vislines {
+	total := len(e.jsonlines)
+	if e.lazy != nil {
+		total = e.lazy.lines
+	}
+	if total <= vislines && e.lazy == nil {
 		e.scrolly = 0
 		// we have enough room to store the entire buffer
 		return
 	}
-	var sline int
+	var sline, eline int
+	if e.lazy != nil {
+		sline, eline = e.lazy.lineof(pos), e.lazy.lineof(pos+count)
+	} else {
+		sline, eline = e.linesof(pos, count)
+	}
+
+	// sline = the starting line of the element to scroll to
+	// eline = the ending line of the element to scroll to
+	// vislines = the number of visible lines on screen
+	// e.scrolly = the current scroll position
+
+	// is the entire selection already visible?
+	if eline-sline <= vislines && sline >= e.scrolly && eline <= e.scrolly+vislines {
+		// the entire is already on screen
+	} else {
+		e.scrolly = sline - 1
+	}
+	if e.scrolly > total-vislines {
+		e.scrolly = total - vislines
+	}
+	if e.scrolly < 0 {
+		e.scrolly = 0
+	}
+	if e.lazy != nil {
+		// the lines that are wider than the screen wrap, which can push the
+		// end of the selection below the screen
+		for e.scrolly < sline && e.lazy.rows(e.scrolly, eline, e.w, vislines) > vislines {
+			e.scrolly++
+		}
+	}
+}
+
+// linesof returns the first and last line of the count bytes at pos.
+func (e *Editor) linesof(pos, count int) (sline, eline int) {
 	// get the line for the pos
 	var i int
 
@@ -333,7 +702,7 @@ func (e *Editor) scrollintoview(pos, count int) {
 			break
 		}
 	}
-	eline := sline + 1
+	eline = sline + 1
 	for ; i < len(e.jsonlines); i++ {
 		eline = i
 		if pos+count <= e.jsonlines[i] {
@@ -343,38 +712,20 @@ func (e *Editor) scrollintoview(pos, count int) {
 			break
 		}
 	}
-
-	// sline = the starting line of the element to scroll to
-	// eline = the ending line of the element to scroll to
-	// vislines = the number of visible lines on screen
-	// e.scrolly = the current scroll position
-
-	// is the entire selection already visible?
-	if eline-sline <= vislines && sline >= e.scrolly && eline <= e.scrolly+vislines {
-		// the entire is already on screen
-	} else {
-		e.scrolly = sline - 1
-	}
-	if e.scrolly < 0 {
-		e.scrolly = 0
-	}
-	if e.scrolly > len(e.jsonlines)-vislines {
-		e.scrolly = len(e.jsonlines) - vislines
-	}
+	return sline, eline
 }
 
 func (e *Editor) blitres() {
 	e.resy = e.y
 	e.fg = lightGray
 	defer e.resetcolors()
+	if e.lazy != nil {
+		e.blitlargeres()
+		return
+	}
 	if e.invalid || e.result.Index == 0 {
-		if len(e.hintkeys) > 0 && e.hintel.Type == gjson.JSON { //&& e.hintel.Raw[0] == '{' {
+		if hkey, ok := e.curhint(); ok && !hkey.new && e.hintel.Type == gjson.JSON { //&& e.hintel.Raw[0] == '{' {
 			s := 0
-			idx := e.hintline % len(e.hintkeys)
-			if idx < 0 {
-				idx = len(e.hintkeys) + idx
-			}
-			hkey := e.hintkeys[idx]
 			var hres gjson.Result
 			if e.hintel.Raw[0] == '[' {
 				hres = hkey.val
@@ -382,29 +733,30 @@ func (e *Editor) blitres() {
 				hres = hkey.key
 			}
 			e.scrollintoview(hres.Index, len(hres.Raw))
-			e.blitstr(e.root.Raw[s:hres.Index])
+			e.blitmarked(s, hres.Index)
 			s = hres.Index + len(hres.Raw)
 			e.fg = hintColor
 			e.res1x, e.res1y = e.x, e.y
-			e.blitstr(e.root.Raw[hres.Index:s])
+			e.blitstr(e.blitroot(hres.Index, s))
 			e.res2x, e.res2y = e.x, e.y
 			e.fg = lightGray
-			e.blitstr(e.root.Raw[s:])
+			e.blitmarked(s, len(e.root.Raw))
 		} else {
 			e.res1x, e.res1y = e.x, e.y
-			e.blitstr(e.root.Raw)
+			e.blitmarked(0, len(e.root.Raw))
 			e.res2x, e.res2y = 0, 0
 		}
 		return
 	}
+	end := e.result.Index + len(e.result.Raw)
 	e.scrollintoview(e.result.Index, len(e.result.Raw))
-	e.blitstr(e.root.Raw[:e.result.Index])
+	e.blitmarked(0, e.result.Index)
 	e.fg = highlight
 	e.res1x, e.res1y = e.x, e.y
-	e.blitstr(e.root.Raw[e.result.Index : e.result.Index+len(e.result.Raw)])
+	e.blitmarked(e.result.Index, end)
 	e.res2x, e.res2y = e.x, e.y
 	e.fg = lightGray
-	e.blitstr(e.root.Raw[e.result.Index+len(e.result.Raw):])
+	e.blitmarked(end, len(e.root.Raw))
 }
 
 func (e *Editor) blitpath() {
@@ -439,6 +791,10 @@ func (e *Editor) centerstr(s string) string {
 }
 
 func (e *Editor) blitstatus() {
+	if e.bulkmode && !e.editmode {
+		e.blitbulkstatus()
+		return
+	}
 	e.statusy = e.y
 	e.barval = ""
 	var barstr string
@@ -504,7 +860,13 @@ func (e *Editor) blitstatus() {
 		barstr += strings.Repeat(" ", e.w-len(barstr))
 	}
 	e.newline()
+	y := e.y
 	e.blitstr(barstr)
+	if e.editmode && !e.renamemode {
+		e.blittype()
+	} else {
+		e.blitschemamsg(y)
+	}
 	e.resetcolors()
 }
 
@@ -523,7 +885,7 @@ func (e *Editor) exechints() {
 		if el, ok := e.vpathels[vpath]; ok {
 			e.hintel = el
 		} else {
-			e.hintel = gjson.Get(e.root.Raw, vpath)
+			e.hintel = e.get(vpath)
 			e.vpathels[vpath] = e.hintel
 		}
 	}
@@ -531,6 +893,9 @@ func (e *Editor) exechints() {
 		var keys []hintkey
 		var num float64
 		e.hintel.ForEach(func(key, val gjson.Result) bool {
+			if e.lazy != nil && num == maxLargeHints {
+				return false
+			}
 			if e.hintel.Raw[0] == '[' {
 				key = gjson.Result{Type: gjson.Number, Num: num}
 			}
@@ -539,19 +904,38 @@ func (e *Editor) exechints() {
 					key.Index += e.hintel.Index
 				}
 				val.Index += e.hintel.Index
-				keys = append(keys, hintkey{key, val})
+				keys = append(keys, hintkey{key: key, val: val})
 			}
 			num++
 			return true
 		})
+		if e.schema != nil {
+			keys = append(keys, e.newhints(e.hintel, e.parts[len(e.parts)-1])...)
+		}
 		e.hintkeys = keys
 	}
 }
 
+// curhint returns the selected hint.
+func (e *Editor) curhint() (hintkey, bool) {
+	if len(e.hintkeys) == 0 {
+		return hintkey{}, false
+	}
+	idx := e.hintline % len(e.hintkeys)
+	if idx < 0 {
+		idx = len(e.hintkeys) + idx
+	}
+	return e.hintkeys[idx], true
+}
+
 func (e *Editor) exec() {
 	defer func() {
 		e.exechints()
 	}()
+	if e.bulkmode && e.path != e.bulkpath {
+		e.bulkmode = false
+		e.bulkpaths, e.bulkres = nil, nil
+	}
 
 	e.parts, e.esc = parsePath(e.path)
 	if e.path == "" {
@@ -559,7 +943,7 @@ func (e *Editor) exec() {
 		e.invalid = true
 		return
 	}
-	res := gjson.Get(e.root.Raw, e.path)
+	res := e.get(e.path)
 	if !res.Exists() {
 		e.invalid = true
 		return
@@ -628,27 +1012,41 @@ func (e *Editor) addrune(r rune) {
 	e.redraw()
 }
 
+// stopedit leaves the edit bar, along with the suggested values.
+func (e *Editor) stopedit() {
+	e.editmode = false
+	e.renamemode = false
+	e.insertmode = false
+	e.suggests, e.suggestidx = nil, -1
+}
+
 func (e *Editor) completeedit() {
-	var njson []byte
-	var err error
-	if valid(e.editval) {
-		njson, err = sjson.SetRawBytes(e.json, e.path, []byte(e.editval))
-	} else {
-		njson, err = sjson.SetBytes(e.json, e.path, e.editval)
+	if e.renamemode {
+		e.completerename()
+		return
+	}
+	if e.insertmode {
+		e.completeinsert()
+		return
+	}
+	if e.bulkmode {
+		e.completebulkedit()
+		return
+	}
+	raw, err := typedvalue(e.editval, e.edittype)
+	if err != nil {
+		e.seterr(err)
+		e.redraw()
+		return
 	}
+	njson, err := setraw(e.json, e.apath(), raw)
 	if err != nil {
 		e.writeerr = err
 		e.writets = time.Now()
 	} else {
-		e.undos = append(e.undos, *e)
-		if len(e.undos) > maxUndos {
-			e.undos = e.undos[len(e.undos)-maxUndos:]
-		} else {
-			e.undoidx++
-		}
-		e.json = njson
+		e.commit(e.apath(), njson)
 	}
-	e.editmode = false
+	e.stopedit()
 	e.editdirty = true
 	e.reflow()
 }
@@ -658,28 +1056,23 @@ func (e *Editor) delete() {
 	ppidx := e.pidx
 	var njson []byte
 	e.completehint(false)
-	if e.path == "" {
+	if e.apath() == "" {
 		if e.root.Type != gjson.JSON || len(e.json) == 2 {
 			njson = []byte("")
 		}
 	} else {
 		var err error
-		njson, err = sjson.DeleteBytes(e.json, e.path)
+		njson, err = sjson.DeleteBytes(e.json, e.apath())
 		if err != nil {
 			e.writeerr = err
 			e.writets = time.Now()
+			njson = e.json
 		}
 	}
-	e.undos = append(e.undos, *e)
-	if len(e.undos) > maxUndos {
-		e.undos = e.undos[len(e.undos)-maxUndos:]
-	} else {
-		e.undoidx++
-	}
-	e.json = njson
+	e.commit(e.apath(), njson)
 	e.path = ppath
 	e.pidx = ppidx
-	e.editmode = false
+	e.stopedit()
 	e.editdirty = true
 	e.reflow()
 }
@@ -701,15 +1094,6 @@ func (e *Editor) completehint(adddot bool) {
 	}
 	e.redraw()
 }
-func (e *Editor) undo() {
-	if e.undoidx > 0 {
-		*e = e.undos[e.undoidx-1]
-		e.redraw()
-	}
-}
-func (e *Editor) redo() {
-}
-
 func (e *Editor) writeOut() {
 	e.widx = len(e.writeval)
 	e.writemode = true
@@ -717,6 +1101,10 @@ func (e *Editor) writeOut() {
 }
 
 func (e *Editor) addwriterune(c rune) {
+	e.writeconfirm = false
+	e.lossconfirm = false
+	e.validconfirm = false
+	e.reviewed = false
 	e.writeval += string(c)
 	e.widx++
 	e.writeredraw()
@@ -726,6 +1114,15 @@ func (e *Editor) writeredraw() {
 		termbox.SetCell(x, e.h-2, ' ', termbox.ColorBlack, termbox.ColorWhite)
 	}
 	prompt := "File Name to Write: "
+	if e.promptmode == promptRecord {
+		prompt = "Record: "
+	} else if e.lossconfirm {
+		prompt = "Enter to write anyway: "
+	} else if e.validconfirm {
+		prompt = "Invalid, Enter to write anyway: "
+	} else if e.writeconfirm {
+		prompt = "Changed on disk, Enter to overwrite: "
+	}
 	x := 0
 	for _, c := range prompt {
 		termbox.SetCell(x, e.h-2, c, termbox.ColorBlack, termbox.ColorWhite)
@@ -735,6 +1132,14 @@ func (e *Editor) writeredraw() {
 		termbox.SetCell(x, e.h-2, c, termbox.ColorBlack, termbox.ColorWhite)
 		x++
 	}
+	if e.promptmode == promptWrite && e.styled(e.writeval) {
+		style := "[" + e.style.String() + "]"
+		for i, c := range style {
+			if sx := e.w - len(style) + i; sx > x {
+				termbox.SetCell(sx, e.h-2, c, termbox.ColorBlack, termbox.ColorWhite)
+			}
+		}
+	}
 	termbox.SetCursor(x-(len(e.writeval)-e.widx), e.h-2)
 	e.blithelp()
 	e.bliterr()
@@ -742,17 +1147,112 @@ func (e *Editor) writeredraw() {
 }
 func (e *Editor) completewrite() {
 	e.writets = time.Now()
-	if err := ioutil.WriteFile(e.writeval, e.json, e.perm); err != nil {
+	if f, _ := formatof(e.writeval); f.tabular() {
+		e.export(f, e.writeval)
+		return
+	}
+	if !e.reviewed && e.startreview() {
+		return
+	}
+	loaded := e.stamp.path != "" && samefile(e.writeval, e.stamp.path)
+	if loaded && !e.writeconfirm && e.stamp.changed() {
+		e.writeconfirm = true
+		e.writeerr = errChanged
+		e.writeredraw()
+		return
+	}
+	if loaded && !e.lossconfirm && len(e.lost) > 0 {
+		e.lossconfirm = true
+		e.writeerr = fmt.Errorf("writing drops the %s", strings.Join(e.lost, ", "))
+		e.writeredraw()
+		return
+	}
+	if e.schema != nil && !e.validconfirm {
+		root := gjson.ParseBytes(e.json)
+		if errs := e.schema.validate(root, e.format == formatNDJSON); len(errs) > 0 {
+			e.validconfirm = true
+			e.writeerr = fmt.Errorf("%d values don't match the schema", len(errs))
+			e.writeredraw()
+			return
+		}
+	}
+	e.writeconfirm = false
+	e.lossconfirm = false
+	e.validconfirm = false
+	e.reviewed = false
+	out, err := e.output(e.writeval)
+	if err != nil {
+		e.writeerr = err
+		e.writeredraw()
+		return
+	}
+	raw, err := compress(e.codecfor(e.writeval), out)
+	if err == nil {
+		err = savefile(e.writeval, raw, e.perm, e.backup)
+	}
+	if err != nil {
 		e.writeerr = err
 		e.writeredraw()
 		return
 	}
+	if loaded && e.format == formatJSON && e.styled(e.writeval) && !bytes.Equal(out, e.json) {
+		// the buffer takes the layout of the file, which can be undone
+		e.commit(e.apath(), out)
+	}
+	if loaded || e.stamp.path == "" {
+		// a copy written somewhere else leaves the loaded file unsaved, but
+		// stdin has no file of its own
+		e.savedidx = e.undoidx
+	}
+	if loaded {
+		e.stamp = stampfile(e.stamp.path, raw)
+		e.lost = nil
+		e.base = e.json
+	}
+	if e.journal != nil && samefile(e.writeval, e.journal.doc) {
+		if err := e.journal.rebase(e, e.json); err != nil {
+			e.writeerr = err
+			e.writeredraw()
+			return
+		}
+	}
 	e.writemode = false
 	e.writeerr = errors.New("written")
-	e.redraw()
+	e.reflow()
 }
+
+// output returns the buffer as it is written to path, in the format of the
+// path's extension or else the format of the loaded file. The loaded file
+// keeps its format.
+func (e *Editor) output(path string) ([]byte, error) {
+	f, ok := formatof(path)
+	loaded := e.stamp.path != "" && samefile(path, e.stamp.path)
+	if !ok || loaded {
+		f = e.format
+	} else if f == formatJSON && (e.format == formatNDJSON || e.format == formatJSONC) {
+		// a .json file can hold JSON Lines or comments, which is found
+		// from its content
+		f = e.format
+	}
+	if e.jsonc != nil && (f == formatJSON || f == formatJSONC) {
+		return e.jsonc.source(e.json), nil
+	}
+	if f == formatJSON {
+		return e.style.apply(e.json), nil
+	}
+	return encode(f, e.json)
+}
+
 func (e *Editor) cancelwrite() {
+	if e.promptmode == promptRecord {
+		e.promptmode = promptWrite
+		e.writeval = e.promptval
+	}
 	e.writemode = false
+	e.writeconfirm = false
+	e.lossconfirm = false
+	e.validconfirm = false
+	e.reviewed = false
 	e.writeerr = nil
 	e.writets = time.Time{}
 	e.redraw()
@@ -760,14 +1260,16 @@ func (e *Editor) cancelwrite() {
 
 // runloop runs the engine
 func (e *Editor) runloop() error {
-	if err := termbox.Init(); err != nil {
-		return err
-	}
-	defer termbox.Close()
-	termbox.SetOutputMode(termbox.Output256)
-	e.reflow()
-
 	for {
+		if e.reviewmode {
+			switch ev := termbox.PollEvent(); ev.Type {
+			case termbox.EventKey:
+				e.reviewkey(ev)
+			case termbox.EventResize:
+				e.redraw()
+			}
+			continue
+		}
 		if e.writemode {
 			switch ev := termbox.PollEvent(); ev.Type {
 			case termbox.EventKey:
@@ -778,10 +1280,19 @@ func (e *Editor) runloop() error {
 						e.cancelwrite()
 						break
 					}
+					if ev.Ch == 0 && (ev.Key == 6 || ev.Key == 19 || ev.Key == 14) {
+						// Ctrl-F, Ctrl-S, Ctrl-N
+						e.setstyle(ev.Key)
+						break
+					}
 					if ev.Ch != 0 {
 						e.addwriterune(ev.Ch)
 					}
 				case termbox.KeyBackspace, termbox.KeyBackspace2:
+					e.writeconfirm = false
+					e.lossconfirm = false
+					e.validconfirm = false
+					e.reviewed = false
 					if len(e.writeval) > 0 {
 						if e.widx >= len(e.writeval) {
 							e.writeval = e.writeval[:len(e.writeval)-1]
@@ -813,11 +1324,49 @@ func (e *Editor) runloop() error {
 				case termbox.KeySpace:
 					e.addwriterune(' ')
 				case termbox.KeyEnter:
-					e.completewrite()
+					if e.promptmode == promptRecord {
+						e.completegoto()
+					} else {
+						e.completewrite()
+					}
 				}
 			}
 			continue
 		}
+		if e.listmode {
+			switch ev := termbox.PollEvent(); ev.Type {
+			case termbox.EventKey:
+				if err := e.listkey(ev); err != nil {
+					return err
+				}
+			case termbox.EventResize:
+				e.reflow()
+			}
+			continue
+		}
+		if e.panemode {
+			switch ev := termbox.PollEvent(); ev.Type {
+			case termbox.EventKey:
+				if e.repairmode && ev.Ch == 0 && ev.Key == 24 {
+					return nil // Ctrl-X, exit
+				}
+				e.panekey(ev)
+			case termbox.EventResize:
+				e.redraw()
+			}
+			continue
+		}
+		if e.reloadmode {
+			switch ev := termbox.PollEvent(); ev.Type {
+			case termbox.EventKey:
+				e.reloadkey(ev)
+			case termbox.EventResize:
+				e.redraw()
+			case termbox.EventInterrupt:
+				e.checkdisk()
+			}
+			continue
+		}
 		switch ev := termbox.PollEvent(); ev.Type {
 		case termbox.EventKey:
 			switch ev.Key {
@@ -827,40 +1376,142 @@ func (e *Editor) runloop() error {
 					break
 					//return nil // Ctrl-C, exit
 				}
+				if ev.Ch == 0 && ev.Key == 4 && e.bulkmode {
+					e.bulkdelete()
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 6 && !e.editmode {
+					// Ctrl-F, bulk edit every match
+					if e.bulkmode {
+						e.stopbulk()
+					} else {
+						e.startbulk()
+					}
+					break
+				}
 				if ev.Ch == 0 && ev.Key == 4 {
 					e.delete()
 					break
 					//return nil // Ctrl-C, exit
 				}
-				if ev.Ch == 0 && ev.Key == 3 {
+				if e.selectreg {
+					e.selectregister(ev.Ch)
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 3 && !e.editmode {
+					// Ctrl-C, copy
+					e.copy()
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 23 && !e.editmode {
+					// Ctrl-W, cut
+					e.cut()
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 22 && !e.editmode {
+					// Ctrl-V, paste
+					e.paste(false)
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 16 && !e.editmode {
+					// Ctrl-P, paste as a new element or key
+					e.paste(true)
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 7 && !e.editmode {
+					// Ctrl-G, choose a register
+					e.selectreg = true
+					e.seterr(errors.New("register?"))
+					e.redraw()
 					break
-					//return nil // Ctrl-C, exit
 				}
 				if ev.Ch == 0 && ev.Key == 24 {
 					return nil // Ctrl-X, exit
 				}
+				if ev.Ch == 0 && ev.Key == 17 && !e.editmode && !e.bulkmode {
+					// Ctrl-Q, next schema error
+					e.nexterror()
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 14 && !e.editmode {
+					// Ctrl-N, next buffer
+					if err := e.nextbuffer(); err != nil {
+						return err
+					}
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 12 && !e.editmode {
+					// Ctrl-L, list buffers
+					e.openlist()
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 20 && !e.editmode && !e.bulkmode {
+					// Ctrl-T, go to record
+					e.gotorecord()
+					break
+				}
 				if ev.Ch == 0 && ev.Key == 25 {
 					// Ctrl-Y, redo
-					//e.redo()
+					e.redo()
 					break
 				}
 				if ev.Ch == 0 && ev.Key == 26 {
-					// Ctrl-Z, redo
+					// Ctrl-Z, undo
 					e.undo()
 					break
 				}
+				if ev.Ch == 0 && ev.Key == 18 {
+					// Ctrl-R, rename
+					if !e.editmode {
+						e.rename()
+					}
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 1 && !e.editmode {
+					// Ctrl-A, insert after
+					e.insert(true)
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 2 && !e.editmode {
+					// Ctrl-B, insert before
+					e.insert(false)
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 21 && !e.editmode {
+					// Ctrl-U, duplicate
+					e.duplicate()
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 11 && !e.editmode {
+					// Ctrl-K, move up
+					e.move(false)
+					break
+				}
+				if ev.Ch == 0 && ev.Key == 10 && !e.editmode {
+					// Ctrl-J, move down
+					e.move(true)
+					break
+				}
 				if ev.Ch == 0 && ev.Key == 5 {
 					// Ctrl-E, edit
 					if e.editmode {
-						e.editmode = false
+						e.stopedit()
 						e.exec()
 						e.redraw()
 					} else {
-						e.completehint(false)
+						if !e.bulkmode {
+							e.completehint(false)
+						}
+						if !e.bulkmode && (!e.invalid || e.path == "") && e.result.Type == gjson.JSON {
+							e.openpane()
+							break
+						}
 						e.editmode = true
 						e.editval = e.barval
 						e.eidx = len(e.editval)
+						e.edittype = typeAuto
 						e.exec()
+						e.startsuggest()
 						e.redraw()
 					}
 					break
@@ -929,29 +1580,58 @@ func (e *Editor) runloop() error {
 				e.redraw()
 			case termbox.KeySpace:
 				e.addrune(' ')
-			case termbox.KeyTab, termbox.KeyEnter:
+			case termbox.KeyTab:
+				if e.editmode {
+					if !e.renamemode {
+						e.cycletype()
+					}
+				} else {
+					e.completehint(true)
+				}
+			case termbox.KeyEnter:
 				if e.editmode {
 					e.completeedit()
 				} else {
 					e.completehint(true)
 				}
 			case termbox.KeyArrowDown:
+				if e.editmode {
+					e.suggest(true)
+					break
+				}
 				e.hintline++
 				e.exec()
 				e.redraw()
 			case termbox.KeyArrowUp:
+				if e.editmode {
+					e.suggest(false)
+					break
+				}
 				e.hintline--
 				e.exec()
 				e.redraw()
+			case termbox.KeyPgup:
+				if e.recview && !e.editmode && !e.bulkmode {
+					e.showrecord(e.record - 1)
+				}
+			case termbox.KeyPgdn:
+				if e.recview && !e.editmode && !e.bulkmode {
+					e.showrecord(e.record + 1)
+				}
 			case termbox.KeyEsc:
+				if e.bulkmode && !e.editmode {
+					e.stopbulk()
+				}
 				if e.editmode {
-					e.editmode = false
+					e.stopedit()
 					e.exec()
 					e.redraw()
 				}
 			}
 		case termbox.EventResize:
 			e.reflow()
+		case termbox.EventInterrupt:
+			e.checkdisk()
 		}
 	}
 }
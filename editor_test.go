@@ -0,0 +1,71 @@
+package jd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputFormat(t *testing.T) {
+	tests := []struct {
+		name, content string
+		target        string // the file written, "" for the loaded one
+		want          string
+	}{
+		{"events.json", "{\"a\":1}\n{\"a\":2}\n", "", "{\"a\":1}\n{\"a\":2}\n"},
+		{"events.json", "{\"a\":1}\n{\"a\":2}\n", "copy.json", "{\"a\":1}\n{\"a\":2}\n"},
+		{"events.json", "{\"a\":1}\n{\"a\":2}\n", "copy.yaml", "- a: 1\n- a: 2\n"},
+		{"events.jsonl", "{\"a\":1}\n{\"a\":2}\n", "copy.json", "{\"a\":1}\n{\"a\":2}\n"},
+		{"conf.json", "{\"a\": 1, // one\n}\n", "", "{\"a\": 1, // one\n}\n"},
+		{"conf.json", "{\"a\":1}", "", "{\"a\":1}"},
+		{"conf.yaml", "a: 1\n", "copy.json", "{\n  \"a\": 1\n}\n"},
+		{"conf.yaml", "a: 1\n", "", "a: 1\n"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		e, err := openbuffer(path, &Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := path
+		if tt.target != "" {
+			target = filepath.Join(dir, tt.target)
+		}
+		out, err := e.output(target)
+		if err != nil {
+			t.Errorf("%s → %s: %v", tt.name, tt.target, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s → %s:\ngot  %q\nwant %q", tt.name, tt.target, out, tt.want)
+		}
+	}
+}
+
+func TestRepairReason(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"{\"a\": 1 2}", "line 1, col 9: expected ',' or '}'"},
+		{"{\n  // note\n  a: Infinity,\n}\n", "line 3, col 6: Infinity can't be held in json"},
+		{"[1, -NaN]", "line 1, col 6: -NaN can't be held in json"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "doc.json")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		e, err := openbuffer(path, &Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !e.repairmode || e.repairmsg != tt.want {
+			t.Errorf("%q: repair %t %q, want %q", tt.content, e.repairmode, e.repairmsg, tt.want)
+		}
+	}
+}
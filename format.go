@@ -0,0 +1,92 @@
+package jd
+
+import (
+	gojson "encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// format is the format of a file. The json buffer always holds json, files
+// in other formats are converted when they are loaded and written.
+type format int
+
+const (
+	formatJSON format = iota
+	formatNDJSON
+	formatYAML
+	formatTOML
+	formatJSONC
+	formatCSV
+	formatTSV
+	formatMarkdown
+)
+
+// formatof returns the format for the extension of path, which comes
+// before the extension of a codec.
+func formatof(path string) (format, bool) {
+	switch strings.ToLower(filepath.Ext(trimcodec(path))) {
+	case ".json":
+		return formatJSON, true
+	case ".jsonl", ".ndjson":
+		return formatNDJSON, true
+	case ".yaml", ".yml":
+		return formatYAML, true
+	case ".toml":
+		return formatTOML, true
+	case ".jsonc", ".json5":
+		return formatJSONC, true
+	case ".csv":
+		return formatCSV, true
+	case ".tsv":
+		return formatTSV, true
+	case ".md", ".markdown":
+		return formatMarkdown, true
+	}
+	return formatJSON, false
+}
+
+// detectformat returns the format of the file at path with content b.
+func detectformat(path string, b []byte) format {
+	if f, ok := formatof(path); ok && f != formatJSON && !f.tabular() {
+		return f
+	}
+	if isndjson(b) {
+		return formatNDJSON
+	}
+	if !gojson.Valid(b) && isjsonc(b) {
+		return formatJSONC
+	}
+	return formatJSON
+}
+
+// decode converts b from format f to json. It also returns what the json
+// can't hold and is lost when writing the file back.
+func decode(f format, b []byte) (json []byte, lost []string, err error) {
+	switch f {
+	case formatNDJSON:
+		return ndjsontoarray(b), nil, nil
+	case formatYAML:
+		return yamltojson(b)
+	case formatTOML:
+		return tomltojson(b)
+	case formatJSONC:
+		json, _, err := jsonctojson(b)
+		return json, nil, err
+	}
+	return b, nil, nil
+}
+
+// encode converts json to format f.
+func encode(f format, json []byte) ([]byte, error) {
+	switch f {
+	case formatNDJSON:
+		return arraytondjson(json), nil
+	case formatYAML:
+		return jsontoyaml(json), nil
+	case formatTOML:
+		return jsontotoml(json)
+	case formatCSV, formatTSV, formatMarkdown:
+		return jsontotable(f, json)
+	}
+	return json, nil
+}
@@ -0,0 +1,254 @@
+package jd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	gojson "encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// journal is an append-only log of the undo history that lives next to the
+// edited file. Its header holds a hash of the file content that the history
+// is relative to, so a journal is never applied to a file that has changed
+// since. After the header come the ops that are already part of the file,
+// followed by the edits, undos and redos of each session in order. The raw
+// bytes of an op are base64 encoded because a change can start or end in
+// the middle of a multibyte character.
+//
+//	{"hash":"9f86d0...","undoidx":2}
+//	{"op":"hist","path":"name","pos":8,"prev":"IlRvbSI=","next":"IlNhbSI="}
+//	{"op":"edit","path":"age","pos":21,"prev":"Mzc=","next":"Mzg="}
+//	{"op":"undo"}
+type journal struct {
+	path string // the journal file
+	doc  string // the document the journal belongs to
+	f    *os.File
+}
+
+type journalheader struct {
+	Hash    string `json:"hash"`
+	Undoidx int    `json:"undoidx"`
+}
+
+type journalentry struct {
+	Op   string `json:"op"`
+	Path string `json:"path,omitempty"`
+	Pos  int    `json:"pos,omitempty"`
+	Prev []byte `json:"prev,omitempty"`
+	Next []byte `json:"next,omitempty"`
+}
+
+var errBadJournal = errors.New("invalid journal")
+
+func journalpath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".jd-journal")
+}
+
+func contenthash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// openjournal opens the journal for the document at path. When a journal
+// for the same content already exists, its history is replayed into e and
+// the returned bool tells whether it held edits that were never written.
+func openjournal(e *Editor, path string) (*journal, bool, error) {
+	j := &journal{path: journalpath(path), doc: path}
+	json := e.json
+	recovered, err := j.replay(e)
+	if err != nil && !os.IsNotExist(err) {
+		// stale or unreadable, start over from the current content.
+		e.json = json
+		e.undos, e.undoidx, e.undosize, e.savedidx = nil, 0, 0, 0
+		e.setpath("")
+		recovered = false
+	}
+	if err == nil {
+		j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, false, err
+		}
+		return j, recovered, nil
+	}
+	if err := j.rebase(e, e.json); err != nil {
+		return nil, false, err
+	}
+	return j, recovered, nil
+}
+
+func (j *journal) replay(e *Editor) (bool, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	rd := bufio.NewReader(f)
+	line, err := rd.ReadBytes('\n')
+	if err != nil {
+		return false, errBadJournal
+	}
+	var hdr journalheader
+	if err := gojson.Unmarshal(line, &hdr); err != nil {
+		return false, errBadJournal
+	}
+	if hdr.Hash != contenthash(e.json) {
+		return false, errBadJournal
+	}
+	base := e.json
+	var started bool
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil {
+			// a partial last line is what a crash mid-write leaves
+			// behind, so only complete lines are replayed.
+			break
+		}
+		var ent journalentry
+		if err := gojson.Unmarshal(line, &ent); err != nil {
+			return false, errBadJournal
+		}
+		op := undoop{
+			path: ent.Path, pos: ent.Pos,
+			prev: ent.Prev, next: ent.Next,
+		}
+		if ent.Op == "hist" {
+			if started {
+				return false, errBadJournal
+			}
+			e.undos = append(e.undos, op)
+			e.undosize += op.size()
+			continue
+		}
+		if !started {
+			if hdr.Undoidx > len(e.undos) {
+				return false, errBadJournal
+			}
+			e.undoidx = hdr.Undoidx
+			e.savedidx = hdr.Undoidx
+			started = true
+		}
+		switch ent.Op {
+		default:
+			return false, errBadJournal
+		case "edit":
+			if !op.fits(e.json, op.prev) {
+				return false, errBadJournal
+			}
+			op.segs = e.jsonc.splice(e.json, op.pos, len(op.prev), op.next)
+			e.json = splice(e.json, op.pos, len(op.prev), op.next)
+			e.pushundo(op)
+			continue
+		case "undo":
+			if e.undoidx == 0 || !e.undos[e.undoidx-1].fits(e.json, e.undos[e.undoidx-1].next) {
+				return false, errBadJournal
+			}
+			e.undoidx--
+			op = e.undos[e.undoidx]
+			e.jsonc.unsplice(e.json, op)
+			e.json = splice(e.json, op.pos, len(op.next), op.prev)
+		case "redo":
+			if e.undoidx == len(e.undos) || !e.undos[e.undoidx].fits(e.json, e.undos[e.undoidx].prev) {
+				return false, errBadJournal
+			}
+			op = e.undos[e.undoidx]
+			e.undoidx++
+			e.jsonc.splice(e.json, op.pos, len(op.prev), op.next)
+			e.json = splice(e.json, op.pos, len(op.prev), op.next)
+		}
+		e.path = op.path
+	}
+	if !started {
+		if hdr.Undoidx > len(e.undos) {
+			return false, errBadJournal
+		}
+		e.undoidx = hdr.Undoidx
+		e.savedidx = hdr.Undoidx
+	}
+	e.setpath(e.path)
+	return string(base) != string(e.json), nil
+}
+
+// fits tells whether b is found at the op position of json.
+func (op undoop) fits(json, b []byte) bool {
+	return op.pos >= 0 && op.pos+len(b) <= len(json) &&
+		string(json[op.pos:op.pos+len(b)]) == string(b)
+}
+
+// append writes an entry and syncs it, so a crash loses no more than the
+// edit that was being made.
+func (j *journal) append(v interface{}) error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	b, _ := gojson.Marshal(v)
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (j *journal) edit(op undoop) error {
+	return j.append(journalentry{
+		Op: "edit", Path: op.path, Pos: op.pos,
+		Prev: op.prev, Next: op.next,
+	})
+}
+
+func (j *journal) undo() error {
+	return j.append(journalentry{Op: "undo"})
+}
+
+func (j *journal) redo() error {
+	return j.append(journalentry{Op: "redo"})
+}
+
+// rebase rewrites the journal so that the history is relative to content,
+// which is what the document holds on disk. This also compacts the
+// journal down to the in-memory history.
+func (j *journal) rebase(e *Editor, content []byte) error {
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	b, _ := gojson.Marshal(journalheader{Hash: contenthash(content), Undoidx: e.undoidx})
+	w.Write(append(b, '\n'))
+	for _, op := range e.undos {
+		b, _ := gojson.Marshal(journalentry{
+			Op: "hist", Path: op.path, Pos: op.pos,
+			Prev: op.prev, Next: op.next,
+		})
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+func (j *journal) close() {
+	if j != nil && j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+}
@@ -0,0 +1,578 @@
+package jd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// JSONC and JSON5 files are edited as plain json. Comments and trailing
+// commas are blanked out with spaces and the JSON5 tokens that json does
+// not have are converted, which leaves valid json for the path bar and the
+// edits. Each of those spots is a segment that remembers its text in the
+// file, so the file is written back with all of its comments, except for
+// the ones inside of a value that was changed or deleted.
+
+// jsoncseg is a spot where the json buffer differs from the file.
+type jsoncseg struct {
+	pos, n  int    // the bytes in the json buffer
+	src     []byte // the bytes in the file
+	comment bool
+}
+
+type jsonc struct {
+	segs []jsoncseg
+}
+
+// source returns the file content for json.
+func (c *jsonc) source(json []byte) []byte {
+	var b []byte
+	var last int
+	for _, s := range c.segs {
+		b = append(b, json[last:s.pos]...)
+		b = append(b, s.src...)
+		last = s.pos + s.n
+	}
+	return append(b, json[last:]...)
+}
+
+// srcpos returns the position in the file of position i in the json
+// buffer.
+func (c *jsonc) srcpos(i int) int {
+	var d int
+	for _, s := range c.segs {
+		if s.pos >= i {
+			break
+		}
+		if i < s.pos+s.n {
+			return s.pos + d
+		}
+		d += len(s.src) - s.n
+	}
+	return i + d
+}
+
+// splice moves the segments along with a splice of the json buffer, which
+// replaces n bytes at pos with b. The segments in the replaced bytes are
+// dropped, except for comments that belong to the values around it: a
+// comment at the end of the line where the splice starts, and comments on
+// their own line right before the values that follow. It returns the
+// segments from before the splice for undo.
+func (c *jsonc) splice(json []byte, pos, n int, b []byte) []jsoncseg {
+	if c == nil {
+		return nil
+	}
+	prev := c.segs
+	end := pos + n
+	next := byte('\n')
+	if len(b) > 0 {
+		next = b[0]
+	} else if end < len(json) {
+		next = json[end]
+	}
+	var segs, before, after, tail []jsoncseg
+	for _, s := range c.segs {
+		switch {
+		case s.n > 0 && s.pos+s.n <= pos || s.n == 0 && s.pos <= pos:
+			if string(s.src) == "," && len(bytes.TrimSpace(b)) > 0 &&
+				len(bytes.TrimSpace(json[s.pos+s.n:pos])) == 0 {
+				// a value follows the trailing comma now
+				continue
+			}
+			segs = append(segs, s)
+		case s.pos >= end:
+			if s.comment && jsoncdeleted(json, pos, end, b, s) {
+				// hide its blanks and the spaces before it
+				s.n += s.pos - end
+				s.pos, s.src, s.comment = end, nil, false
+			}
+			s.pos += len(b) - n
+			tail = append(tail, s)
+		case !s.comment:
+		case jsonctrailing(json, pos, s):
+			s.src = jsonccomment(s.src, pos > 0 && !isspace(json[pos-1]), next, nil)
+			s.pos, s.n = pos, 0
+			before = append(before, s)
+		case jsoncleading(json, end, s):
+			if end < len(json) {
+				next = json[end]
+			}
+			s.src = jsonccomment(s.src, false, next, lineindent(json, pos))
+			s.pos, s.n = pos+len(b), 0
+			after = append(after, s)
+		}
+	}
+	segs = append(segs, before...)
+	segs = append(segs, after...)
+	c.segs = append(segs, tail...)
+	return prev
+}
+
+// unsplice reverts the segments of the json buffer for undoing op.
+func (c *jsonc) unsplice(json []byte, op undoop) {
+	if c == nil {
+		return
+	}
+	if op.segs != nil {
+		c.segs = op.segs
+		return
+	}
+	c.splice(json, op.pos, len(op.next), op.prev)
+}
+
+// jsonccomment returns the text of a comment that's kept in a new spot. A
+// line comment needs a line break when more text follows.
+func jsonccomment(src []byte, space bool, next byte, indent []byte) []byte {
+	var b []byte
+	if space {
+		b = append(b, ' ')
+	}
+	b = append(b, src...)
+	if bytes.HasPrefix(src, []byte("//")) && next != '\n' && next != '\r' {
+		b = append(b, '\n')
+		b = append(b, indent...)
+	}
+	return b
+}
+
+func isspace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// linestart returns the start of the line of pos.
+func linestart(json []byte, pos int) int {
+	return bytes.LastIndexByte(json[:pos], '\n') + 1
+}
+
+// jsonctrailing tells whether the comment ends the line where a splice at
+// pos starts, after a value that's still there.
+func jsonctrailing(json []byte, pos int, s jsoncseg) bool {
+	if end := s.pos + s.n; end < len(json) && json[end] != '\n' && json[end] != '\r' {
+		return false
+	}
+	start := s.pos
+	if start > pos {
+		if bytes.IndexByte(json[pos:start], '\n') != -1 {
+			return false
+		}
+		start = pos
+	}
+	return len(bytes.TrimSpace(json[linestart(json, start):start])) > 0
+}
+
+// jsoncdeleted tells whether the comment follows on the line of a value
+// that the splice deletes.
+func jsoncdeleted(json []byte, pos, end int, b []byte, s jsoncseg) bool {
+	if len(bytes.TrimSpace(b)) > 0 || bytes.IndexByte(json[end:s.pos], '\n') != -1 {
+		return false
+	}
+	ls := linestart(json, end)
+	if ls < pos {
+		if len(bytes.TrimSpace(json[ls:pos])) > 0 {
+			return false
+		}
+		ls = pos
+	}
+	return len(bytes.TrimSpace(json[ls:end])) > 0
+}
+
+// jsoncleading tells whether the comment is on its own line and only
+// whitespace follows it up to the end of a splice, so it belongs to the
+// value after the splice.
+func jsoncleading(json []byte, end int, s jsoncseg) bool {
+	if len(bytes.TrimSpace(json[linestart(json, s.pos):s.pos])) > 0 {
+		return false
+	}
+	return s.pos+s.n > end || len(bytes.TrimSpace(json[s.pos+s.n:end])) == 0
+}
+
+type jsoncparser struct {
+	s    []byte
+	i    int
+	json []byte
+	segs []jsoncseg
+}
+
+// isjsonc tells whether b is a JSONC or JSON5 document that isn't plain
+// json.
+func isjsonc(b []byte) bool {
+	_, c, err := jsonctojson(b)
+	return err == nil && len(c.segs) > 0
+}
+
+// jsonctojson converts a JSONC or JSON5 document to json.
+func jsonctojson(b []byte) ([]byte, *jsonc, error) {
+	p := &jsoncparser{s: b, json: make([]byte, 0, len(b))}
+	if bytes.HasPrefix(b, []byte("\ufeff")) {
+		p.i = 3
+		p.convert(0, nil, false)
+	}
+	if err := p.ws(); err != nil {
+		return nil, nil, err
+	}
+	if p.i < len(p.s) {
+		if err := p.value(); err != nil {
+			return nil, nil, err
+		}
+		if err := p.ws(); err != nil {
+			return nil, nil, err
+		}
+		if p.i < len(p.s) {
+			return nil, nil, p.errorf("unexpected %q after the document", p.s[p.i])
+		}
+	}
+	sort.SliceStable(p.segs, func(i, j int) bool {
+		return p.segs[i].pos < p.segs[j].pos
+	})
+	return p.json, &jsonc{segs: p.segs}, nil
+}
+
+func (p *jsoncparser) errorf(format string, args ...interface{}) error {
+	return newparseerror(p.s, p.i, fmt.Sprintf(format, args...))
+}
+
+// convert adds the file bytes from s up to the current position as json.
+func (p *jsoncparser) convert(s int, json []byte, comment bool) {
+	if !comment && string(json) == string(p.s[s:p.i]) {
+		p.json = append(p.json, json...)
+		return
+	}
+	p.segs = append(p.segs, jsoncseg{
+		pos: len(p.json), n: len(json),
+		src:     append([]byte(nil), p.s[s:p.i]...),
+		comment: comment,
+	})
+	p.json = append(p.json, json...)
+}
+
+// ws skips whitespace and blanks out comments.
+func (p *jsoncparser) ws() error {
+	for p.i < len(p.s) {
+		s := p.i
+		switch {
+		case isspace(p.s[p.i]):
+			p.i++
+			p.json = append(p.json, p.s[s])
+			continue
+		case bytes.HasPrefix(p.s[p.i:], []byte("//")):
+			for p.i < len(p.s) && p.s[p.i] != '\n' && p.s[p.i] != '\r' {
+				p.i++
+			}
+		case bytes.HasPrefix(p.s[p.i:], []byte("/*")):
+			end := bytes.Index(p.s[p.i+2:], []byte("*/"))
+			if end == -1 {
+				return p.errorf("unterminated comment")
+			}
+			p.i += end + 4
+		default:
+			return nil
+		}
+		blank := make([]byte, p.i-s)
+		for i := range blank {
+			blank[i] = ' '
+			if c := p.s[s+i]; c == '\n' || c == '\r' {
+				blank[i] = c
+			}
+		}
+		p.convert(s, blank, true)
+	}
+	return nil
+}
+
+func (p *jsoncparser) value() error {
+	if p.i == len(p.s) {
+		return p.errorf("unexpected end of the document")
+	}
+	switch c := p.s[p.i]; c {
+	case '{', '[':
+		return p.collection(c)
+	case '"', '\'':
+		return p.str()
+	case 't', 'f', 'n':
+		for _, lit := range []string{"true", "false", "null"} {
+			if bytes.HasPrefix(p.s[p.i:], []byte(lit)) {
+				p.i += len(lit)
+				p.json = append(p.json, lit...)
+				return nil
+			}
+		}
+		return p.errorf("unexpected %q", c)
+	}
+	s := p.i
+	for p.i < len(p.s) && bytes.IndexByte([]byte("0123456789abcdefABCDEFxX.+-"), p.s[p.i]) != -1 {
+		p.i++
+	}
+	for p.i < len(p.s) && isident(p.s[p.i]) {
+		// Infinity and NaN
+		p.i++
+	}
+	tok := string(p.s[s:p.i])
+	if isjsonnumber(tok) {
+		p.json = append(p.json, tok...)
+		return nil
+	}
+	num, err := json5number(tok)
+	if err != nil {
+		// the error is at the digits or the name, after any sign
+		p.i = s
+		if tok != "" && (tok[0] == '+' || tok[0] == '-') {
+			p.i++
+		}
+		return p.errorf("%v", err)
+	}
+	p.convert(s, []byte(num), false)
+	return nil
+}
+
+// isjsonnumber tells whether s is a valid json number.
+func isjsonnumber(s string) bool {
+	digits := func(i int) int {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	if i < len(s) && s[i] == '0' {
+		i++
+	} else if i < len(s) && s[i] >= '1' && s[i] <= '9' {
+		i = digits(i)
+	} else {
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		if i = digits(i + 1); s[i-1] == '.' {
+			return false
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		d := i
+		if i = digits(i); i == d {
+			return false
+		}
+	}
+	return i == len(s)
+}
+
+// json5number returns a JSON5 number like '0x1F', '.5' or '+1' as json.
+func json5number(tok string) (string, error) {
+	t := tok
+	var neg bool
+	if len(t) > 0 && (t[0] == '+' || t[0] == '-') {
+		neg = t[0] == '-'
+		t = t[1:]
+	}
+	switch t {
+	case "Infinity", "NaN":
+		return "", fmt.Errorf("%s can't be held in json", tok)
+	}
+	var num string
+	if len(t) > 2 && t[0] == '0' && (t[1] == 'x' || t[1] == 'X') {
+		n, err := strconv.ParseUint(t[2:], 16, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %q", tok)
+		}
+		num = strconv.FormatUint(n, 10)
+	} else if isjsonnumber(t) {
+		num = t
+	} else {
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil || t == "" || t[0] < '.' || t[0] > '9' {
+			return "", fmt.Errorf("invalid value %q", tok)
+		}
+		num = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	if neg && num != "0" {
+		num = "-" + num
+	}
+	return num, nil
+}
+
+func isident(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '$' || c >= 0x80
+}
+
+// str converts a single or double quoted string, with the escapes of
+// JSON5, to a json string.
+func (p *jsoncparser) str() error {
+	s := p.i
+	q := p.s[p.i]
+	p.i++
+	plain := q == '"'
+	for ; p.i < len(p.s) && p.s[p.i] != q; p.i++ {
+		switch c := p.s[p.i]; {
+		case c == '\\':
+			p.i++
+			if p.i == len(p.s) {
+				break
+			}
+			switch p.s[p.i] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't', 'u':
+			default:
+				plain = false
+			}
+		case c == '\n':
+			return p.errorf("unterminated string")
+		case c < ' ':
+			plain = false
+		}
+	}
+	if p.i == len(p.s) {
+		p.i = s
+		return p.errorf("unterminated string")
+	}
+	p.i++
+	if plain {
+		p.json = append(p.json, p.s[s:p.i]...)
+		return nil
+	}
+	str, ok := json5string(p.s[s+1 : p.i-1])
+	if !ok {
+		p.i = s
+		return p.errorf("invalid escape in string")
+	}
+	p.convert(s, appendJSONString(nil, str), false)
+	return nil
+}
+
+// json5string unescapes the inside of a JSON5 string.
+func json5string(s []byte) (string, bool) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			// a line continuation
+			if i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			} else {
+				b = append(b, '\r')
+			}
+		case 't':
+			b = append(b, '\t')
+		case 'v':
+			b = append(b, '\v')
+		case '0':
+			b = append(b, 0)
+		case '\n':
+		case 'x', 'u':
+			n := 2
+			if c == 'u' {
+				n = 4
+			}
+			if i+n >= len(s) {
+				return "", false
+			}
+			r, err := strconv.ParseUint(string(s[i+1:i+1+n]), 16, 32)
+			if err != nil {
+				return "", false
+			}
+			i += n
+			if utf16.IsSurrogate(rune(r)) && i+6 < len(s) && s[i+1] == '\\' && s[i+2] == 'u' {
+				if r2, err := strconv.ParseUint(string(s[i+3:i+7]), 16, 32); err == nil {
+					r = uint64(utf16.DecodeRune(rune(r), rune(r2)))
+					i += 6
+				}
+			}
+			b = append(b, string(rune(r))...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b), true
+}
+
+// collection converts an object or array. Keys of objects may be JSON5
+// identifiers and a trailing comma is blanked out.
+func (p *jsoncparser) collection(open byte) error {
+	close := byte(']')
+	if open == '{' {
+		close = '}'
+	}
+	p.i++
+	p.json = append(p.json, open)
+	for {
+		if err := p.ws(); err != nil {
+			return err
+		}
+		if p.i == len(p.s) {
+			return p.errorf("expected '%c'", close)
+		}
+		if p.s[p.i] == close {
+			p.i++
+			p.json = append(p.json, close)
+			return nil
+		}
+		if open == '{' {
+			if err := p.key(); err != nil {
+				return err
+			}
+			if err := p.ws(); err != nil {
+				return err
+			}
+			if p.i == len(p.s) || p.s[p.i] != ':' {
+				return p.errorf("expected ':'")
+			}
+			p.i++
+			p.json = append(p.json, ':')
+			if err := p.ws(); err != nil {
+				return err
+			}
+		}
+		if err := p.value(); err != nil {
+			return err
+		}
+		if err := p.ws(); err != nil {
+			return err
+		}
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			comma := len(p.json)
+			p.i++
+			p.json = append(p.json, ',')
+			if err := p.ws(); err != nil {
+				return err
+			}
+			if p.i < len(p.s) && p.s[p.i] == close {
+				p.json[comma] = ' '
+				p.segs = append(p.segs, jsoncseg{pos: comma, n: 1, src: []byte{','}})
+			}
+		} else if p.i == len(p.s) || p.s[p.i] != close {
+			return p.errorf("expected ',' or '%c'", close)
+		}
+	}
+}
+
+func (p *jsoncparser) key() error {
+	if p.s[p.i] == '"' || p.s[p.i] == '\'' {
+		return p.str()
+	}
+	s := p.i
+	for p.i < len(p.s) && isident(p.s[p.i]) {
+		p.i++
+	}
+	if s == p.i {
+		return p.errorf("expected a key")
+	}
+	p.convert(s, appendJSONString(nil, string(p.s[s:p.i])), false)
+	return nil
+}
@@ -0,0 +1,477 @@
+package jd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tidwall/gjson"
+)
+
+// Documents over largeSize bytes are shown in large-file mode. Rather than
+// pretty printing the whole document on each change, it's indexed once
+// into checkpoints of where its formatted lines start, and only the lines
+// on screen are formatted. An edit only indexes the lines around what
+// changed, and the layout doesn't depend on the width of the terminal, so
+// a resize needs no indexing at all.
+const largeSize = 1024 * 1024
+
+// lazyevery is the number of lines between checkpoints.
+const lazyevery = 1024
+
+// lazywidth is the width that an array or object must fit in to be shown
+// on a single line.
+const lazywidth = 80
+
+// maxLargeHints is the number of keys of an object or array that are
+// looked at for hints in large-file mode, where an array can have millions
+// of elements.
+const maxLargeHints = 1000
+
+// lazycp is a checkpoint, the start of a formatted line.
+type lazycp struct {
+	pos   int // offset of the first token of the line
+	depth int // the indentation of the line
+	line  int // the line number
+}
+
+type lazyview struct {
+	json  string
+	cps   []lazycp
+	lines int
+}
+
+// lazyspan colors the bytes from start to end of the json.
+type lazyspan struct {
+	start, end int
+	fg         termbox.Attribute
+}
+
+func newlazyview(json string) *lazyview {
+	v := &lazyview{json: json}
+	v.scan(lazycp{pos: skipws(json, 0)}, nil, 0, 0)
+	return v
+}
+
+// update returns the view of json, which is an edited copy of the json of
+// v. The lines are indexed again from a little before the change up to
+// the first checkpoint after it, and the checkpoints that follow are moved
+// by the change in size.
+func (v *lazyview) update(json string) *lazyview {
+	if len(v.cps) == 0 {
+		return newlazyview(json)
+	}
+	p := commonprefix(v.json, json)
+	end := len(json) - commonsuffix(v.json[p:], json[p:])
+	// a line can look ahead for whether an array fits on it, so the lines
+	// from the checkpoint before the change are indexed again too
+	k := sort.Search(len(v.cps), func(i int) bool { return v.cps[i].pos >= p }) - 2
+	if k < 0 {
+		k = 0
+	}
+	nv := &lazyview{json: json, cps: append([]lazycp(nil), v.cps[:k]...)}
+	nv.scan(v.cps[k], v, end, len(json)-len(v.json))
+	return nv
+}
+
+// scan indexes the lines from cp to the end of the json. With an old view,
+// it stops at the first line at or after sync that the old view has a
+// checkpoint for, which is delta bytes further than in the old json, and
+// takes the rest of the checkpoints from the old view.
+func (v *lazyview) scan(cp lazycp, old *lazyview, sync, delta int) {
+	pos, depth, line := cp.pos, cp.depth, cp.line
+	for n := 0; pos < len(v.json); n++ {
+		if old != nil && pos >= sync {
+			j := sort.Search(len(old.cps), func(j int) bool {
+				return old.cps[j].pos+delta >= pos
+			})
+			if j < len(old.cps) && old.cps[j].pos+delta == pos && old.cps[j].depth == depth {
+				d := line - old.cps[j].line
+				for _, cp := range old.cps[j:] {
+					cp.pos += delta
+					cp.line += d
+					v.cps = append(v.cps, cp)
+				}
+				v.lines = old.lines + d
+				return
+			}
+		}
+		if n%lazyevery == 0 {
+			v.cps = append(v.cps, lazycp{pos, depth, line})
+		}
+		pos, depth = v.line(pos, depth, nil)
+		line++
+	}
+	v.lines = line
+}
+
+// seek returns the start and depth of a line, and its number, from the
+// checkpoint before the line that fn is first true for.
+func (v *lazyview) seek(fn func(cp lazycp) bool) lazycp {
+	i := sort.Search(len(v.cps), func(i int) bool { return fn(v.cps[i]) }) - 1
+	if i < 0 {
+		return lazycp{pos: len(v.json)}
+	}
+	return v.cps[i]
+}
+
+// lineof returns the number of the line that shows position pos.
+func (v *lazyview) lineof(pos int) int {
+	cp := v.seek(func(cp lazycp) bool { return cp.pos > pos })
+	for cp.pos < len(v.json) {
+		next, depth := v.line(cp.pos, cp.depth, nil)
+		if next > pos {
+			break
+		}
+		cp.pos, cp.depth = next, depth
+		cp.line++
+	}
+	return cp.line
+}
+
+// rows returns the number of screen rows that the lines from first to last
+// take at width, where a line that's wider wraps. It stops counting after
+// max rows.
+func (v *lazyview) rows(first, last, width, max int) int {
+	cp := v.seek(func(cp lazycp) bool { return cp.line > first })
+	for cp.line < first && cp.pos < len(v.json) {
+		cp.pos, cp.depth = v.line(cp.pos, cp.depth, nil)
+		cp.line++
+	}
+	var n int
+	for ; cp.line <= last && cp.pos < len(v.json) && n <= max; cp.line++ {
+		var runes int
+		cp.pos, cp.depth = v.line(cp.pos, cp.depth, func(c byte, _ int) {
+			if utf8.RuneStart(c) {
+				runes++
+			}
+		})
+		n++
+		if runes > width {
+			n += (runes - 1) / width
+		}
+	}
+	return n
+}
+
+// line formats the line that starts at pos, passing each character and
+// the position in the json that it's for to emit, which may be nil. It
+// returns the start and depth of the next line. The lines are laid out
+// like the pretty printer does.
+func (v *lazyview) line(pos, depth int, emit func(c byte, at int)) (int, int) {
+	s := v.json
+	i := pos
+	closing := s[i] == '}' || s[i] == ']'
+	if closing && depth > 0 {
+		depth--
+	}
+	if emit != nil {
+		for j := 0; j < depth*2; j++ {
+			emit(' ', pos-1)
+		}
+	}
+	if closing {
+		v.emitraw(i, i+1, emit)
+		return v.comma(i+1, emit), depth
+	}
+	col := depth * 2
+	if s[i] == '"' {
+		end := lazystring(s, i)
+		if j := skipws(s, end); j < len(s) && s[j] == ':' {
+			v.emitraw(i, end, emit)
+			if emit != nil {
+				emit(':', j)
+				emit(' ', j)
+			}
+			col += end - i + 2
+			i = skipws(s, j+1)
+		}
+	}
+	if i < len(s) && (s[i] == '{' || s[i] == '[') {
+		if end, ok := v.fits(i, lazywidth-col-1); ok {
+			v.compact(i, end, emit)
+			return v.comma(end, emit), depth
+		}
+		v.emitraw(i, i+1, emit)
+		return skipws(s, i+1), depth + 1
+	}
+	end := lazytoken(s, i)
+	v.emitraw(i, end, emit)
+	return v.comma(end, emit), depth
+}
+
+// comma adds the comma that follows a value to its line.
+func (v *lazyview) comma(i int, emit func(c byte, at int)) int {
+	i = skipws(v.json, i)
+	if i < len(v.json) && v.json[i] == ',' {
+		v.emitraw(i, i+1, emit)
+		i = skipws(v.json, i+1)
+	}
+	return i
+}
+
+func (v *lazyview) emitraw(i, end int, emit func(c byte, at int)) {
+	if emit == nil {
+		return
+	}
+	for ; i < end; i++ {
+		emit(v.json[i], i)
+	}
+}
+
+// fits tells whether the array or object at i fits in limit characters
+// when it's compacted, and returns its end.
+func (v *lazyview) fits(i, limit int) (int, bool) {
+	s := v.json
+	var n, nest int
+	for i < len(s) && n <= limit {
+		switch s[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+			continue
+		case '"':
+			end := lazystring(s, i)
+			n += end - i
+			i = end
+			continue
+		case '{', '[':
+			nest++
+		case '}', ']':
+			nest--
+			if nest == 0 {
+				return i + 1, n+1 <= limit
+			}
+		case ':', ',':
+			n++
+		}
+		n++
+		i++
+	}
+	return 0, false
+}
+
+// compact formats the array or object from i to end on a single line.
+func (v *lazyview) compact(i, end int, emit func(c byte, at int)) {
+	if emit == nil {
+		return
+	}
+	s := v.json
+	for i < end {
+		switch c := s[i]; c {
+		case ' ', '\t', '\r', '\n':
+		case '"':
+			str := lazystring(s, i)
+			v.emitraw(i, str, emit)
+			i = str
+			continue
+		case ':', ',':
+			emit(c, i)
+			emit(' ', i)
+		default:
+			emit(c, i)
+		}
+		i++
+	}
+}
+
+func skipws(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\r' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// lazystring returns the end of the string at i.
+func lazystring(s string, i int) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// lazytoken returns the end of the string, number or literal at i.
+func lazytoken(s string, i int) int {
+	if s[i] == '"' {
+		return lazystring(s, i)
+	}
+	j := i
+	for j < len(s) {
+		switch s[j] {
+		case ' ', '\t', '\r', '\n', ',', ':', '{', '}', '[', ']', '"':
+			if j == i {
+				return j + 1
+			}
+			return j
+		}
+		j++
+	}
+	return j
+}
+
+// commonprefix returns the length of the common prefix of a and b.
+func commonprefix(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	var i int
+	for i+4096 <= n && a[i:i+4096] == b[i:i+4096] {
+		i += 4096
+	}
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// commonsuffix returns the length of the common suffix of a and b.
+func commonsuffix(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	var i int
+	for i+4096 <= n && a[len(a)-i-4096:len(a)-i] == b[len(b)-i-4096:len(b)-i] {
+		i += 4096
+	}
+	for i < n && a[len(a)-i-1] == b[len(b)-i-1] {
+		i++
+	}
+	return i
+}
+
+// reflowlarge shows json in large-file mode. A resize keeps the index and
+// an edit only indexes around the change.
+func (e *Editor) reflowlarge(json []byte) {
+	if e.lazy != nil && !e.editdirty {
+		return
+	}
+	root := gjson.ParseBytes(json)
+	if e.lazy == nil {
+		e.blitloading(len(json))
+		e.lazy = newlazyview(root.Raw)
+	} else {
+		e.lazy = e.lazy.update(root.Raw)
+	}
+	e.root = root
+	e.jsonlines = nil
+}
+
+// blitloading shows a notice while a large buffer is being formatted.
+func (e *Editor) blitloading(n int) {
+	e.resetcolors()
+	termbox.Clear(e.bg, e.fg)
+	e.x, e.y = 0, 0
+	e.blitstr(fmt.Sprintf("loading %d MB buffer", n/1024/1024))
+	e.newline()
+	e.blitstr("please wait...")
+	termbox.Flush()
+}
+
+// blitlarge draws the lines on screen, starting at line top, with the
+// spans in their colors.
+func (e *Editor) blitlarge(top int, spans []lazyspan) {
+	v := e.lazy
+	spans = e.markspans(spans)
+	cp := v.seek(func(cp lazycp) bool { return cp.line > top })
+	for cp.line < top && cp.pos < len(v.json) {
+		cp.pos, cp.depth = v.line(cp.pos, cp.depth, nil)
+		cp.line++
+	}
+	var buf []byte
+	var ats []int
+	emit := func(c byte, at int) {
+		buf = append(buf, c)
+		ats = append(ats, at)
+	}
+	e.y = e.resy
+	for cp.pos < len(v.json) && e.y < e.h {
+		buf, ats = buf[:0], ats[:0]
+		cp.pos, cp.depth = v.line(cp.pos, cp.depth, emit)
+		e.x = 0
+		for s := 0; s < len(buf); {
+			fg := lazycolor(spans, ats[s])
+			i := s + 1
+			for i < len(buf) && (!utf8.RuneStart(buf[i]) || lazycolor(spans, ats[i]) == fg) {
+				i++
+			}
+			e.fg = fg
+			e.blitstr(string(buf[s:i]))
+			s = i
+		}
+		if e.x > 0 || len(buf) == 0 {
+			e.newline()
+		}
+	}
+	e.fg = lightGray
+}
+
+// lazycolor returns the color of the byte at at. The spans are in order.
+func lazycolor(spans []lazyspan, at int) termbox.Attribute {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].start > at }) - 1
+	if i >= 0 && at < spans[i].end {
+		return spans[i].fg
+	}
+	return lightGray
+}
+
+// blitlargeres draws the result, or the hinted key, like blitres does.
+func (e *Editor) blitlargeres() {
+	if !e.invalid && e.result.Index != 0 {
+		e.scrollintoview(e.result.Index, len(e.result.Raw))
+		e.blitlarge(e.scrolly, []lazyspan{
+			{e.result.Index, e.result.Index + len(e.result.Raw), highlight},
+		})
+		return
+	}
+	if len(e.hintkeys) == 0 || e.hintel.Type != gjson.JSON {
+		e.blitlarge(0, nil)
+		return
+	}
+	idx := e.hintline % len(e.hintkeys)
+	if idx < 0 {
+		idx = len(e.hintkeys) + idx
+	}
+	hres := e.hintkeys[idx].key
+	if e.hintel.Raw[0] == '[' {
+		hres = e.hintkeys[idx].val
+	}
+	e.scrollintoview(hres.Index, len(hres.Raw))
+	e.blitlarge(e.scrolly, []lazyspan{{hres.Index, hres.Index + len(hres.Raw), hintColor}})
+}
+
+// get returns the value at path. In large-file mode the values of paths
+// are remembered until the next change, and a path is looked up from the
+// value of its longest known parent, so typing a path doesn't search the
+// whole document on each key.
+func (e *Editor) get(path string) gjson.Result {
+	if e.lazy == nil || strings.ContainsAny(path, "\\*?#|@") {
+		return gjson.Get(e.root.Raw, path)
+	}
+	var res gjson.Result
+	var found bool
+	parts := strings.Split(path, ".")
+	for i := len(parts) - 1; i > 0 && !found; i-- {
+		el, ok := e.vpathels[strings.Join(parts[:i], ".")]
+		if ok && el.Type == gjson.JSON && el.Index > 0 {
+			res = gjson.Get(el.Raw, strings.Join(parts[i:], "."))
+			// the position isn't known for every kind of result
+			found = !res.Exists() || res.Index > 0
+			res.Index += el.Index
+		}
+	}
+	if !found {
+		res = gjson.Get(e.root.Raw, path)
+	}
+	if res.Type == gjson.JSON {
+		e.vpathels[path] = res
+	}
+	return res
+}
@@ -0,0 +1,216 @@
+package jd
+
+import (
+	"bytes"
+	gojson "encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// An NDJSON document, which has one json value per line, is edited as a
+// json array with one record per element. The records can be shown one at
+// a time, in which case the path bar works within the current record, or
+// all together as a list, where paths like '#.id' reach across all of them.
+
+// The bottom prompt asks for the file name to write, or the record to go
+// to.
+const (
+	promptWrite = iota
+	promptRecord
+)
+
+// isndjson tells whether b holds json lines, which takes more than one line
+// with a json value on it.
+func isndjson(b []byte) bool {
+	if bytes.IndexByte(bytes.TrimSpace(b), '\n') == -1 {
+		return false
+	}
+	var n int
+	for _, line := range bytes.Split(b, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !gojson.Valid(line) {
+			return false
+		}
+		n++
+	}
+	return n > 1
+}
+
+// ndjsontoarray turns json lines into a json array with one record per
+// line.
+func ndjsontoarray(b []byte) []byte {
+	var recs [][]byte
+	for _, line := range bytes.Split(b, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			recs = append(recs, line)
+		}
+	}
+	if len(recs) == 0 {
+		return []byte("[]")
+	}
+	json := []byte("[\n")
+	json = append(json, bytes.Join(recs, []byte(",\n"))...)
+	return append(json, "\n]"...)
+}
+
+// arraytondjson turns the json array back into json lines with one compact
+// record per line.
+func arraytondjson(json []byte) []byte {
+	var buf bytes.Buffer
+	gjson.ParseBytes(json).ForEach(func(_, rec gjson.Result) bool {
+		if gojson.Compact(&buf, []byte(rec.Raw)) != nil {
+			buf.WriteString(rec.Raw)
+		}
+		buf.WriteByte('\n')
+		return true
+	})
+	return buf.Bytes()
+}
+
+// apath returns the path of the selection in the json buffer. In the
+// record view the path bar is relative to the current record.
+func (e *Editor) apath() string {
+	if !e.recview {
+		return e.path
+	}
+	rec := strconv.Itoa(e.record)
+	if e.path == "" {
+		return rec
+	}
+	return rec + "." + e.path
+}
+
+// relpath turns a path in the json buffer into a path for the path bar,
+// moving to another record when needed.
+func (e *Editor) relpath(path string) string {
+	if !e.recview || path == "" {
+		return path
+	}
+	parts := splitquery(path)
+	rec, err := strconv.Atoi(parts[0])
+	if err != nil {
+		e.recview = false
+		e.editdirty = true
+		return path
+	}
+	if rec != e.record {
+		e.record = rec
+		e.editdirty = true
+	}
+	return strings.Join(parts[1:], ".")
+}
+
+// recordcount returns the number of records.
+func (e *Editor) recordcount() int {
+	var n int
+	gjson.ParseBytes(e.json).ForEach(func(_, _ gjson.Result) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// recordraw returns the current record, moving to the last record when
+// the current one no longer exists.
+func (e *Editor) recordraw() []byte {
+	n := e.recordcount()
+	if e.record >= n {
+		e.record = n - 1
+	}
+	if e.record < 0 {
+		e.record = 0
+	}
+	res, ok := locate(e.json, strconv.Itoa(e.record))
+	if !ok {
+		return nil
+	}
+	return []byte(res.Raw)
+}
+
+// showrecord shows the record at idx, or all records when idx is -1.
+func (e *Editor) showrecord(idx int) {
+	if idx == -1 {
+		if e.recview && e.path != "" {
+			e.path = e.apath()
+		}
+		e.recview = false
+	} else {
+		if n := e.recordcount(); idx >= n {
+			idx = n - 1
+		}
+		if idx < 0 {
+			idx = 0
+		}
+		if !e.recview {
+			// keep the selection when it's in the record
+			parts := splitquery(e.path)
+			e.recview = true
+			e.record = -1
+			if parts[0] == strconv.Itoa(idx) {
+				e.record = idx
+				e.path = strings.Join(parts[1:], ".")
+			}
+		}
+		if idx != e.record {
+			e.path = ""
+		}
+		e.record = idx
+	}
+	e.pidx = len(e.path)
+	e.hintline = 0
+	e.editdirty = true
+	e.reflow()
+}
+
+// gotorecord starts the prompt for the record to jump to.
+func (e *Editor) gotorecord() {
+	if e.format != formatNDJSON {
+		e.seterr(errors.New("not a json lines document"))
+		e.redraw()
+		return
+	}
+	e.promptmode = promptRecord
+	e.promptval = e.writeval
+	e.writeval = ""
+	if e.recview {
+		e.writeval = strconv.Itoa(e.record + 1)
+	}
+	e.writeOut()
+}
+
+func (e *Editor) completegoto() {
+	e.writemode = false
+	e.promptmode = promptWrite
+	val := strings.TrimSpace(e.writeval)
+	e.writeval = e.promptval
+	if val == "" {
+		e.showrecord(-1)
+		return
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 1 {
+		e.seterr(fmt.Errorf("invalid record number"))
+		e.redraw()
+		return
+	}
+	e.showrecord(n - 1)
+}
+
+// recordlabel returns the record being shown for the help bar.
+func (e *Editor) recordlabel() string {
+	if e.format != formatNDJSON {
+		return ""
+	}
+	if !e.recview {
+		return " [all records]"
+	}
+	return fmt.Sprintf(" [record %d/%d]", e.record+1, e.recordcount())
+}
@@ -0,0 +1,492 @@
+package jd
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// locate finds the value at path in json and returns it with its Index set
+// to the offset of the value in json. Computed results, such as those from
+// a '#' path, have no location and are not found.
+func locate(json []byte, path string) (gjson.Result, bool) {
+	if path == "" {
+		var i int
+		for i < len(json) && json[i] <= ' ' {
+			i++
+		}
+		res := gjson.ParseBytes(json[i:])
+		if !res.Exists() {
+			return res, false
+		}
+		res.Index = i
+		return res, true
+	}
+	res := gjson.GetBytes(json, path)
+	if !res.Exists() || res.Index+len(res.Raw) > len(json) ||
+		string(json[res.Index:res.Index+len(res.Raw)]) != res.Raw {
+		return res, false
+	}
+	return res, true
+}
+
+// locatekey finds the key token of the object member at path. The returned
+// key has its Index set to the offset of the token in json.
+func locatekey(json []byte, path string) (key gjson.Result, ok bool) {
+	ppath, name := splitpath(path)
+	parent, ok := locate(json, ppath)
+	if !ok || parent.Type != gjson.JSON || parent.Raw[0] != '{' {
+		return key, false
+	}
+	ok = false
+	parent.ForEach(func(k, _ gjson.Result) bool {
+		if k.String() == name {
+			key = k
+			key.Index += parent.Index
+			ok = true
+			return false
+		}
+		return true
+	})
+	return key, ok
+}
+
+// lineindent returns the leading whitespace of the line that pos is on.
+func lineindent(json []byte, pos int) []byte {
+	s := bytes.LastIndexByte(json[:pos], '\n') + 1
+	n := s
+	for n < pos && (json[n] == ' ' || json[n] == '\t') {
+		n++
+	}
+	return json[s:n]
+}
+
+// dedent takes the raw value that starts at pos in json and removes the
+// indentation of its first line from the lines that follow.
+func dedent(json []byte, pos int, raw []byte) []byte {
+	ind := lineindent(json, pos)
+	if len(ind) == 0 {
+		return raw
+	}
+	return bytes.Replace(raw, append([]byte{'\n'}, ind...), []byte{'\n'}, -1)
+}
+
+// indent is the reverse of dedent and lines up a raw value that is about to
+// go at pos in json with the line it starts on.
+func indent(json []byte, pos int, raw []byte) []byte {
+	ind := lineindent(json, pos)
+	if len(ind) == 0 {
+		return raw
+	}
+	return bytes.Replace(raw, []byte{'\n'}, append([]byte{'\n'}, ind...), -1)
+}
+
+// setraw is like sjson.SetRawBytes but takes a dedented raw value and lines
+// it up with where it lands. An empty path replaces the whole document.
+func setraw(json []byte, path string, raw []byte) ([]byte, error) {
+	if path == "" {
+		res, ok := locate(json, path)
+		if !ok {
+			return raw, nil
+		}
+		return splice(json, res.Index, len(res.Raw), raw), nil
+	}
+	njson, err := sjson.SetRawBytes(json, path, raw)
+	if err != nil || bytes.IndexByte(raw, '\n') == -1 {
+		return njson, err
+	}
+	res, ok := locate(njson, path)
+	if !ok {
+		return njson, nil
+	}
+	return splice(njson, res.Index, len(res.Raw), indent(njson, res.Index, raw)), nil
+}
+
+// splitpath splits path at the last unescaped dot into the path of the
+// parent and the unescaped name of the last component.
+func splitpath(path string) (parent, name string) {
+	parts, _ := parsePath(path)
+	name = parts[len(parts)-1]
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] != '.' {
+			continue
+		}
+		var sc int
+		for j := i - 1; j >= 0 && path[j] == '\\'; j-- {
+			sc++
+		}
+		if sc%2 == 0 {
+			return path[:i], name
+		}
+	}
+	return "", name
+}
+
+// joinpath appends the key name to the parent path, escaping any path
+// syntax in name.
+func joinpath(parent, name string) string {
+	var b []byte
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '.', '*', '?', '#', '\\':
+			b = append(b, '\\')
+		}
+		b = append(b, name[i])
+	}
+	if parent == "" {
+		return string(b)
+	}
+	return parent + "." + string(b)
+}
+
+// appendJSONString appends s as a quoted json string. Unlike encoding/json
+// it leaves '<', '>' and '&' alone.
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && n == 1 {
+				buf = append(buf, "\ufffd"...)
+			} else {
+				buf = append(buf, s[i:i+n]...)
+			}
+			i += n
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		default:
+			if c < ' ' {
+				buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			} else {
+				buf = append(buf, c)
+			}
+		}
+		i++
+	}
+	return append(buf, '"')
+}
+
+func (e *Editor) seterr(err error) {
+	e.writeerr = err
+	e.writets = time.Now()
+}
+
+// rename starts editing the name of the selected object key.
+func (e *Editor) rename() {
+	e.completehint(false)
+	if _, ok := locatekey(e.json, e.apath()); !ok {
+		e.seterr(errors.New("not an object key"))
+		e.redraw()
+		return
+	}
+	_, name := splitpath(e.apath())
+	e.editmode = true
+	e.renamemode = true
+	e.editval = name
+	e.eidx = len(e.editval)
+	e.exec()
+	e.redraw()
+}
+
+// completerename rewrites the key token at its position in the json buffer,
+// which keeps the order of the object members.
+func (e *Editor) completerename() {
+	e.stopedit()
+	defer e.reflow()
+	njson, path, err := renamekey(e.json, e.apath(), e.editval)
+	if err != nil {
+		e.seterr(err)
+		return
+	}
+	if njson == nil {
+		return
+	}
+	e.commit(e.apath(), njson)
+	e.setpath(path)
+}
+
+// renamekey returns json with the key at path renamed to name and the new
+// path of the key, or nil when the name is the same.
+func renamekey(json []byte, path, name string) ([]byte, string, error) {
+	key, ok := locatekey(json, path)
+	if !ok {
+		return nil, "", errors.New("not an object key")
+	}
+	ppath, old := splitpath(path)
+	if name == old {
+		return nil, "", nil
+	}
+	if ppath == "" && name == "" {
+		// the path of the key would be the one of the document
+		return nil, "", errors.New("a top-level key can't be empty")
+	}
+	npath := joinpath(ppath, name)
+	if _, ok := locatekey(json, npath); ok {
+		return nil, "", errors.New("duplicate key")
+	}
+	raw := appendJSONString(nil, name)
+	return splice(json, key.Index, len(key.Raw), raw), npath, nil
+}
+
+// arrayel is an element of an array in the json buffer.
+type arrayel struct {
+	parent string // path of the array
+	idx    int    // index of the element
+	els    []gjson.Result
+	open   int // offset of the '['
+}
+
+// locateel finds the array element at path. The elements have their Index
+// set to their offset in json.
+func locateel(json []byte, path string) (el arrayel, ok bool) {
+	ppath, name := splitpath(path)
+	parent, ok := locate(json, ppath)
+	if !ok || parent.Type != gjson.JSON || parent.Raw[0] != '[' {
+		return el, false
+	}
+	idx, err := strconv.Atoi(name)
+	if err != nil || idx < 0 {
+		return el, false
+	}
+	parent.ForEach(func(_, val gjson.Result) bool {
+		val.Index += parent.Index
+		el.els = append(el.els, val)
+		return true
+	})
+	if idx >= len(el.els) {
+		return el, false
+	}
+	el.parent, el.idx, el.open = ppath, idx, parent.Index
+	return el, true
+}
+
+func (el arrayel) start(i int) int { return el.els[i].Index }
+func (el arrayel) end(i int) int   { return el.els[i].Index + len(el.els[i].Raw) }
+
+// sep returns the separator that goes between two elements, which keeps
+// the layout of the surrounding array. Only the line break and indentation
+// are taken from the array, so the blanks of JSONC comments aren't copied.
+func (el arrayel) sep(json []byte) []byte {
+	var sep []byte
+	if len(el.els) > 1 {
+		sep = json[el.end(0):el.start(1)]
+	} else {
+		sep = json[el.open+1 : el.start(0)]
+	}
+	return listsep(sep)
+}
+
+// listsep returns a comma followed by the line break and indentation of
+// the text between two members of an array or object, or a space when
+// they're on the same line.
+func listsep(sep []byte) []byte {
+	if i := bytes.LastIndexByte(sep, '\n'); i != -1 {
+		if i > 0 && sep[i-1] == '\r' {
+			i--
+		}
+		return append([]byte{','}, sep[i:]...)
+	}
+	if len(bytes.TrimSpace(sep)) < len(sep) {
+		return []byte(", ")
+	}
+	return []byte{','}
+}
+
+// insertel inserts the dedented raw value before or after the element at
+// path and returns the updated json and the path of the new element.
+func insertel(json []byte, path string, raw []byte, after bool) ([]byte, string, error) {
+	el, ok := locateel(json, path)
+	if !ok {
+		return nil, "", errors.New("not an array element")
+	}
+	sep := el.sep(json)
+	raw = indent(json, el.start(el.idx), raw)
+	var b []byte
+	var pos, idx int
+	if after {
+		b = append(append(b, sep...), raw...)
+		pos, idx = el.end(el.idx), el.idx+1
+	} else {
+		b = append(append(b, raw...), sep...)
+		pos, idx = el.start(el.idx), el.idx
+	}
+	return splice(json, pos, 0, b), joinpath(el.parent, strconv.Itoa(idx)), nil
+}
+
+// insertkey inserts the object member at path with the dedented raw value.
+// It goes after the closest member before it in src, the json the value is
+// taken from, that's also in json, or else first. It returns false when the
+// parent isn't an object with members or it already has the key.
+func insertkey(json []byte, path string, src, raw []byte) ([]byte, bool) {
+	ppath, name := splitpath(path)
+	parent, ok := locate(json, ppath)
+	if !ok || !isobject(parent) {
+		return nil, false
+	}
+	var keys, vals []gjson.Result
+	has := make(map[string]int)
+	parent.ForEach(func(key, val gjson.Result) bool {
+		key.Index += parent.Index
+		val.Index += parent.Index
+		has[key.String()] = len(keys)
+		keys, vals = append(keys, key), append(vals, val)
+		return true
+	})
+	if _, ok := has[name]; ok || len(keys) == 0 {
+		return nil, false
+	}
+	prev := -1
+	if sparent, ok := locate(src, ppath); ok && isobject(sparent) {
+		var before []string
+		found := false
+		sparent.ForEach(func(key, _ gjson.Result) bool {
+			if key.String() == name {
+				found = true
+				return false
+			}
+			before = append(before, key.String())
+			return true
+		})
+		for i := len(before) - 1; found && i >= 0 && prev == -1; i-- {
+			if j, ok := has[before[i]]; ok {
+				prev = j
+			}
+		}
+	}
+	var sep []byte
+	if len(keys) > 1 {
+		sep = listsep(json[vals[0].Index+len(vals[0].Raw) : keys[1].Index])
+	} else {
+		sep = listsep(json[parent.Index+1 : keys[0].Index])
+	}
+	colon := []byte(":")
+	if bytes.Contains(json[keys[0].Index+len(keys[0].Raw):vals[0].Index], []byte(": ")) {
+		colon = []byte(": ")
+	}
+	member := append(appendJSONString(nil, name), colon...)
+	member = append(member, indent(json, keys[0].Index, raw)...)
+	if prev == -1 {
+		return splice(json, keys[0].Index, 0, append(member, sep...)), true
+	}
+	return splice(json, vals[prev].Index+len(vals[prev].Raw), 0, append(sep, member...)), true
+}
+
+// moveel swaps the element at path with the one before it, or after it
+// when down is true, and returns the updated json and the new path.
+func moveel(json []byte, path string, down bool) ([]byte, string, error) {
+	el, ok := locateel(json, path)
+	if !ok {
+		return nil, "", errors.New("not an array element")
+	}
+	a, b := el.idx-1, el.idx
+	if down {
+		a, b = el.idx, el.idx+1
+	}
+	if a < 0 || b >= len(el.els) {
+		return nil, "", errors.New("cannot move past the end of the array")
+	}
+	var raw []byte
+	raw = append(raw, el.els[b].Raw...)
+	raw = append(raw, json[el.end(a):el.start(b)]...)
+	raw = append(raw, el.els[a].Raw...)
+	idx := a
+	if down {
+		idx = b
+	}
+	njson := splice(json, el.start(a), el.end(b)-el.start(a), raw)
+	return njson, joinpath(el.parent, strconv.Itoa(idx)), nil
+}
+
+// insert starts editing a new array element that goes before or after the
+// selected one.
+func (e *Editor) insert(after bool) {
+	e.completehint(false)
+	el, ok := locateel(e.json, e.apath())
+	if !ok {
+		e.seterr(errors.New("not an array element"))
+		e.redraw()
+		return
+	}
+	e.editmode = true
+	e.insertmode = true
+	e.insertafter = after
+	e.editval = ""
+	e.eidx = 0
+	e.edittype = typeAuto
+	e.exec()
+	// the schema of the element at the index it'll have
+	idx := el.idx
+	if after {
+		idx++
+	}
+	parts := append(e.parts[:len(e.parts)-1:len(e.parts)-1], strconv.Itoa(idx))
+	e.suggestat(parts)
+	e.redraw()
+}
+
+func (e *Editor) completeinsert() {
+	raw, err := typedvalue(e.editval, e.edittype)
+	if err != nil {
+		e.seterr(err)
+		e.redraw()
+		return
+	}
+	e.stopedit()
+	njson, path, err := insertel(e.json, e.apath(), raw, e.insertafter)
+	if err != nil {
+		e.seterr(err)
+	} else {
+		e.commit(e.apath(), njson)
+		e.setpath(path)
+	}
+	e.reflow()
+}
+
+// duplicate inserts a copy of the selected array element after it.
+func (e *Editor) duplicate() {
+	e.completehint(false)
+	el, ok := locateel(e.json, e.apath())
+	if !ok {
+		e.seterr(errors.New("not an array element"))
+		e.redraw()
+		return
+	}
+	raw := dedent(e.json, el.start(el.idx), []byte(el.els[el.idx].Raw))
+	njson, path, err := insertel(e.json, e.apath(), raw, true)
+	if err != nil {
+		e.seterr(err)
+	} else {
+		e.commit(e.apath(), njson)
+		e.setpath(path)
+	}
+	e.reflow()
+}
+
+// move moves the selected array element up or down by one.
+func (e *Editor) move(down bool) {
+	e.completehint(false)
+	njson, path, err := moveel(e.json, e.apath(), down)
+	if err != nil {
+		e.seterr(err)
+	} else {
+		e.commit(e.apath(), njson)
+		e.setpath(path)
+	}
+	e.reflow()
+}
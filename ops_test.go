@@ -0,0 +1,34 @@
+package jd
+
+import "testing"
+
+func TestRenameKey(t *testing.T) {
+	tests := []struct {
+		json, path, name string
+		want, wantpath   string // want is "" when the rename is refused
+	}{
+		{`{"a":1,"b":2}`, "a", "c", `{"c":1,"b":2}`, "c"},
+		{`{"a":1,"b":2}`, "a", "x.y", `{"x.y":1,"b":2}`, `x\.y`},
+		{`{"o":{"a":1}}`, "o.a", "", `{"o":{"":1}}`, "o."},
+		{`{"a":1,"b":2}`, "a", "b", "", ""},
+		{`{"a":1,"b":2}`, "a", "", "", ""},
+		{`[1]`, "0", "x", "", ""},
+	}
+	for _, tt := range tests {
+		got, path, err := renamekey([]byte(tt.json), tt.path, tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: %s → %q: expected an error, got %s", tt.json, tt.path, tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s → %q: %v", tt.json, tt.path, tt.name, err)
+			continue
+		}
+		if string(got) != tt.want || path != tt.wantpath {
+			t.Errorf("%s: %s → %q:\ngot  %s at %q\nwant %s at %q",
+				tt.json, tt.path, tt.name, got, path, tt.want, tt.wantpath)
+		}
+	}
+}
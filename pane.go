@@ -0,0 +1,243 @@
+package jd
+
+import (
+	gojson "encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// The pane is a multi-line editor for objects and arrays. It takes the
+// place of the result view and opens with the pretty printed value at the
+// selected path.
+
+// openpane starts editing the selected object or array in the pane.
+func (e *Editor) openpane() {
+	e.panelines = nil
+	for _, line := range strings.Split(string(pretty([]byte(e.result.Raw), e.w)), "\n") {
+		e.panelines = append(e.panelines, []rune(line))
+	}
+	e.panex, e.paney = 0, 0
+	e.panescrollx, e.panescrolly = 0, 0
+	e.panemode = true
+	e.redraw()
+}
+
+func (e *Editor) closepane() {
+	if e.repairmode {
+		e.repairmsg = "the json can't be parsed, ^X to exit"
+		e.redraw()
+		return
+	}
+	e.panemode = false
+	e.panelines = nil
+	e.exec()
+	e.redraw()
+}
+
+func (e *Editor) panetext() string {
+	lines := make([]string, len(e.panelines))
+	for i, line := range e.panelines {
+		lines[i] = string(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// completepane validates the pane text and replaces the selected value
+// with it. Invalid json keeps the pane open.
+func (e *Editor) completepane() {
+	if e.repairmode {
+		e.completerepair()
+		return
+	}
+	text := e.panetext()
+	if err := validerr(text); err != nil {
+		e.seterr(err)
+		e.redraw()
+		return
+	}
+	res, ok := locate(e.json, e.apath())
+	if !ok {
+		e.seterr(fmt.Errorf("path not found"))
+		e.redraw()
+		return
+	}
+	raw := []byte(strings.TrimSpace(text))
+	if strings.IndexByte(res.Raw, '\n') == -1 {
+		raw = ugly(raw)
+	}
+	njson, err := setraw(e.json, e.apath(), raw)
+	if err != nil {
+		e.seterr(err)
+		e.redraw()
+		return
+	}
+	e.commit(e.apath(), njson)
+	e.panemode = false
+	e.panelines = nil
+	e.reflow()
+}
+
+// validerr is like valid but returns an error with the line and column of
+// the first syntax error.
+func validerr(json string) error {
+	var junk interface{}
+	err := gojson.Unmarshal([]byte(json), &junk)
+	if err == nil {
+		return nil
+	}
+	serr, ok := err.(*gojson.SyntaxError)
+	if !ok {
+		return err
+	}
+	off := int(serr.Offset)
+	if off > len(json) {
+		off = len(json)
+	}
+	if off > 0 {
+		off--
+	}
+	return newparseerror([]byte(json), off, serr.Error())
+}
+
+func (e *Editor) blitpane() {
+	e.resy = e.y
+	vislines := e.h - e.resy - 2
+	if vislines < 1 {
+		vislines = 1
+	}
+	if e.paney < e.panescrolly {
+		e.panescrolly = e.paney
+	} else if e.paney >= e.panescrolly+vislines {
+		e.panescrolly = e.paney - vislines + 1
+	}
+	if e.panex < e.panescrollx {
+		e.panescrollx = e.panex
+	} else if e.panex >= e.panescrollx+e.w {
+		e.panescrollx = e.panex - e.w + 1
+	}
+	for i := 0; i < vislines && e.panescrolly+i < len(e.panelines); i++ {
+		line := e.panelines[e.panescrolly+i]
+		for x := 0; x < e.w && e.panescrollx+x < len(line); x++ {
+			c := line[e.panescrollx+x]
+			if c == '\t' || c == '\r' {
+				// a tab takes a single cell so that the cursor lines up
+				c = ' '
+			}
+			termbox.SetCell(x, e.resy+i, c, termbox.ColorWhite, termbox.ColorDefault)
+		}
+	}
+}
+
+func (e *Editor) panekey(ev termbox.Event) {
+	line := e.panelines[e.paney]
+	switch ev.Key {
+	default:
+		if ev.Ch == 0 && ev.Key == 19 {
+			// Ctrl-S, apply
+			e.completepane()
+			return
+		}
+		if ev.Ch == 0 && ev.Key == 3 {
+			// Ctrl-C, cancel
+			e.closepane()
+			return
+		}
+		if ev.Ch == 0 && ev.Key == 6 && e.repairmode {
+			// Ctrl-F, fix the common problems
+			e.autofix()
+			return
+		}
+		if ev.Ch != 0 {
+			e.paneinsert(ev.Ch)
+		}
+	case termbox.KeyEsc:
+		e.closepane()
+		return
+	case termbox.KeySpace:
+		e.paneinsert(' ')
+	case termbox.KeyTab:
+		e.paneinsert(' ')
+		e.paneinsert(' ')
+	case termbox.KeyEnter:
+		// keep the indentation of the current line
+		var n int
+		for n < e.panex && line[n] == ' ' {
+			n++
+		}
+		nline := append([]rune(strings.Repeat(" ", n)), line[e.panex:]...)
+		e.panelines[e.paney] = line[:e.panex:e.panex]
+		e.panelines = append(e.panelines[:e.paney+1],
+			append([][]rune{nline}, e.panelines[e.paney+1:]...)...)
+		e.paney++
+		e.panex = n
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if e.panex > 0 {
+			e.panelines[e.paney] = append(line[:e.panex-1:e.panex-1], line[e.panex:]...)
+			e.panex--
+		} else if e.paney > 0 {
+			prev := e.panelines[e.paney-1]
+			e.panex = len(prev)
+			e.panelines[e.paney-1] = append(prev[:len(prev):len(prev)], line...)
+			e.panelines = append(e.panelines[:e.paney], e.panelines[e.paney+1:]...)
+			e.paney--
+		}
+	case termbox.KeyDelete:
+		if e.panex < len(line) {
+			e.panelines[e.paney] = append(line[:e.panex:e.panex], line[e.panex+1:]...)
+		} else if e.paney < len(e.panelines)-1 {
+			e.panelines[e.paney] = append(line[:len(line):len(line)], e.panelines[e.paney+1]...)
+			e.panelines = append(e.panelines[:e.paney+1], e.panelines[e.paney+2:]...)
+		}
+	case termbox.KeyArrowLeft:
+		if e.panex > 0 {
+			e.panex--
+		} else if e.paney > 0 {
+			e.paney--
+			e.panex = len(e.panelines[e.paney])
+		}
+	case termbox.KeyArrowRight:
+		if e.panex < len(line) {
+			e.panex++
+		} else if e.paney < len(e.panelines)-1 {
+			e.paney++
+			e.panex = 0
+		}
+	case termbox.KeyArrowUp:
+		e.panemovey(-1)
+	case termbox.KeyArrowDown:
+		e.panemovey(1)
+	case termbox.KeyPgup:
+		e.panemovey(-(e.h - e.resy - 2))
+	case termbox.KeyPgdn:
+		e.panemovey(e.h - e.resy - 2)
+	case termbox.KeyHome:
+		e.panex = 0
+	case termbox.KeyEnd:
+		e.panex = len(line)
+	}
+	e.redraw()
+}
+
+func (e *Editor) paneinsert(r rune) {
+	line := e.panelines[e.paney]
+	nline := make([]rune, 0, len(line)+1)
+	nline = append(nline, line[:e.panex]...)
+	nline = append(nline, r)
+	e.panelines[e.paney] = append(nline, line[e.panex:]...)
+	e.panex++
+}
+
+func (e *Editor) panemovey(n int) {
+	e.paney += n
+	if e.paney < 0 {
+		e.paney = 0
+	}
+	if e.paney >= len(e.panelines) {
+		e.paney = len(e.panelines) - 1
+	}
+	if e.panex > len(e.panelines[e.paney]) {
+		e.panex = len(e.panelines[e.paney])
+	}
+}
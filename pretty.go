@@ -132,7 +132,7 @@ func appendPrettyNumber(buf, json []byte, i, nl int) ([]byte, int, int, bool) {
 
 func appendTabs(buf []byte, indent string, tabs int) []byte {
 	for i := 0; i < tabs; i++ {
-		buf = append(buf, ' ', ' ') //indent...)
+		buf = append(buf, indent...)
 	}
 	return buf
 }
@@ -147,6 +147,19 @@ func ugly(json []byte) []byte {
 	return buf
 }
 
+// prettyindent lays out json with each member on its own line, indented
+// by indent.
+func prettyindent(json []byte, indent string) []byte {
+	buf, _, _, _ := appendPrettyAny(nil, json, 0, true, -1, indent, 0, 0, -1)
+	return buf
+}
+
+// compact lays out json without any whitespace.
+func compact(json []byte) []byte {
+	buf, _, _, _ := appendPrettyAny(nil, json, 0, false, -1, "", 0, 0, -1)
+	return buf
+}
+
 func valid(json string) bool {
 	var junk interface{}
 	return gojson.Unmarshal([]byte(json), &junk) == nil
@@ -0,0 +1,171 @@
+package jd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// watch wakes up the event loop every second until stop is closed, so the
+// current buffer can look for changes to its file.
+func watch(stop chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			termbox.Interrupt()
+		}
+	}
+}
+
+// checkdisk looks for a change to the loaded file. Without local edits the
+// buffer is reloaded, otherwise a notice asks what to do.
+func (e *Editor) checkdisk() {
+	if e.stamp.path == "" || e.editmode {
+		return
+	}
+	if e.disk.path == "" {
+		e.disk = e.stamp
+	}
+	fi, err := os.Stat(e.stamp.path)
+	if err != nil || fi.ModTime().Equal(e.disk.modtime) && fi.Size() == e.disk.size {
+		return
+	}
+	b, err := ioutil.ReadFile(e.stamp.path)
+	if err != nil {
+		return
+	}
+	seen := e.disk
+	e.disk = filestamp{path: e.stamp.path, modtime: fi.ModTime(), size: fi.Size(), hash: contenthash(b)}
+	if e.disk.hash == seen.hash {
+		return
+	}
+	if e.disk.hash == e.stamp.hash {
+		// back to the content that was loaded
+		e.reloadmode = false
+		e.redraw()
+		return
+	}
+	e.reloaddata = b
+	if !e.modified() {
+		e.reload()
+		return
+	}
+	if e.reloaddiff != nil {
+		e.showdiff()
+	}
+	e.reloadmode = true
+	e.redraw()
+}
+
+// reload replaces the buffer with the file on disk, keeping the selected
+// path. The undo history starts over.
+func (e *Editor) reload() {
+	e.reloadmode = false
+	e.reloaddiff = nil
+	e.seterr(errors.New("reloaded"))
+	if err := e.load(e.stamp.path, e.reloaddata); err != nil {
+		e.seterr(fmt.Errorf("not reloaded: %v", err))
+		e.reloaddata = nil
+		e.redraw()
+		return
+	}
+	e.reloaddata = nil
+	e.stamp = e.disk
+	if e.startrepair() {
+		e.seterr(errors.New("reloaded, the json can't be parsed"))
+	}
+	if e.format != formatNDJSON {
+		e.recview = false
+	}
+	e.undos, e.undoidx, e.undosize, e.savedidx = nil, 0, 0, 0
+	if e.journal != nil {
+		if err := e.journal.rebase(e, e.json); err != nil {
+			e.seterr(err)
+		}
+	}
+	e.editdirty = true
+	e.reflow()
+}
+
+// keep closes the notice without reloading. Writing over the file still
+// asks first.
+func (e *Editor) keep() {
+	e.reloadmode = false
+	e.reloaddata = nil
+	e.reloaddiff = nil
+	e.seterr(errors.New("kept your edits"))
+	e.redraw()
+}
+
+// showdiff shows the changes from the buffer to the file on disk.
+func (e *Editor) showdiff() {
+	_, json, _, _, err := decodefile(e.stamp.path, e.reloaddata)
+	if err != nil {
+		e.seterr(err)
+		return
+	}
+	e.reloaddiff = jsondiff(e.json, json, 3)
+	if e.reloaddiff == nil {
+		e.reloaddiff = []diffline{{'@', "no changes"}}
+	}
+	e.diffscroll = 0
+}
+
+// reloadkey handles a key in the reload notice.
+func (e *Editor) reloadkey(ev termbox.Event) {
+	switch ev.Key {
+	default:
+		switch ev.Ch {
+		case 'r', 'R':
+			e.reload()
+			return
+		case 'k', 'K':
+			e.keep()
+			return
+		case 'd', 'D':
+			if e.reloaddiff == nil {
+				e.showdiff()
+			} else {
+				e.reloaddiff = nil
+			}
+		}
+		if ev.Ch == 0 && ev.Key == 3 {
+			// Ctrl-C, keep
+			e.keep()
+			return
+		}
+	case termbox.KeyEsc:
+		e.keep()
+		return
+	case termbox.KeyArrowUp:
+		e.diffscroll--
+	case termbox.KeyArrowDown:
+		e.diffscroll++
+	case termbox.KeyPgup:
+		e.diffscroll -= e.h / 2
+	case termbox.KeyPgdn:
+		e.diffscroll += e.h / 2
+	}
+	if e.diffscroll > len(e.reloaddiff)-1 {
+		e.diffscroll = len(e.reloaddiff) - 1
+	}
+	if e.diffscroll < 0 {
+		e.diffscroll = 0
+	}
+	e.redraw()
+}
+
+func (e *Editor) reloadnotice() string {
+	if e.reloaddiff != nil {
+		return "Changed on disk: - your edits, + on disk"
+	}
+	return "Changed on disk while you have unsaved edits"
+}
@@ -0,0 +1,421 @@
+package jd
+
+import (
+	"bytes"
+	gojson "encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseerror is a syntax error at an offset of the text.
+type parseerror struct {
+	off       int // the offset of the byte that is wrong
+	line, col int
+	msg       string
+}
+
+func (err *parseerror) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", err.line, err.col, err.msg)
+}
+
+func newparseerror(s []byte, off int, msg string) *parseerror {
+	if off > len(s) {
+		off = len(s)
+	}
+	return &parseerror{
+		off:  off,
+		line: bytes.Count(s[:off], []byte{'\n'}) + 1,
+		col:  off - linestart(s, off) + 1,
+		msg:  msg,
+	}
+}
+
+// repairer rewrites broken json as json. It takes the comments, trailing
+// commas, single quotes and unquoted keys of hand written json, and closes
+// what's left open at the end of a truncated document.
+type repairer struct {
+	s     []byte
+	i     int
+	out   []byte
+	fixes []string // what was fixed, in the order it was found
+}
+
+// repairjson returns b with the common problems of broken json fixed,
+// along with a description of each kind of fix. Everything else, like the
+// whitespace, is kept as it is.
+func repairjson(b []byte) ([]byte, []string, error) {
+	r := &repairer{s: b, out: make([]byte, 0, len(b))}
+	if bytes.HasPrefix(b, []byte("\ufeff")) {
+		r.i = 3
+	}
+	if err := r.value(); err != nil {
+		return nil, nil, err
+	}
+	r.ws()
+	if r.i < len(r.s) {
+		return nil, nil, r.errorf("unexpected %q after the document", r.s[r.i])
+	}
+	return r.out, r.fixes, nil
+}
+
+func (r *repairer) errorf(format string, args ...interface{}) error {
+	return newparseerror(r.s, r.i, fmt.Sprintf(format, args...))
+}
+
+func (r *repairer) fix(what string) {
+	for _, f := range r.fixes {
+		if f == what {
+			return
+		}
+	}
+	r.fixes = append(r.fixes, what)
+}
+
+// ws copies the whitespace and drops the comments.
+func (r *repairer) ws() {
+	for r.i < len(r.s) {
+		switch c := r.s[r.i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			r.out = append(r.out, c)
+			r.i++
+		case c == '/' && r.i+1 < len(r.s) && r.s[r.i+1] == '/':
+			r.fix("comments")
+			for r.i < len(r.s) && r.s[r.i] != '\n' {
+				r.i++
+			}
+			ls := bytes.LastIndexByte(r.out, '\n') + 1
+			if r.i < len(r.s) && len(bytes.TrimSpace(r.out[ls:])) == 0 {
+				// a comment on a line of its own takes the line with it
+				r.out = r.out[:ls]
+				r.i++
+			}
+		case c == '/' && r.i+1 < len(r.s) && r.s[r.i+1] == '*':
+			r.fix("comments")
+			end := bytes.Index(r.s[r.i+2:], []byte("*/"))
+			if end == -1 {
+				r.fix("truncated document")
+				r.i = len(r.s)
+			} else {
+				r.i += 2 + end + 2
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (r *repairer) value() error {
+	r.ws()
+	if r.i == len(r.s) {
+		r.fix("truncated document")
+		r.out = append(r.out, "null"...)
+		return nil
+	}
+	switch c := r.s[r.i]; {
+	case c == '{' || c == '[':
+		return r.container()
+	case c == '"' || c == '\'':
+		r.str()
+		return nil
+	case c == '-' || c == '+' || c == '.' || c >= '0' && c <= '9':
+		return r.number()
+	case isidentbyte(c):
+		return r.word()
+	default:
+		return r.errorf("unexpected %q", c)
+	}
+}
+
+func (r *repairer) container() error {
+	obj := r.s[r.i] == '{'
+	end := byte(']')
+	if obj {
+		end = '}'
+	}
+	r.out = append(r.out, r.s[r.i])
+	r.i++
+	for n := 0; ; n++ {
+		mark := len(r.out)
+		r.ws()
+		if r.i == len(r.s) {
+			r.fix("truncated document")
+			r.out = append(r.out, end)
+			return nil
+		}
+		if r.s[r.i] == end {
+			r.out = append(r.out, end)
+			r.i++
+			return nil
+		}
+		if n > 0 {
+			switch c := r.s[r.i]; {
+			case c == ',':
+				r.i++
+				r.ws()
+				if r.i < len(r.s) && r.s[r.i] == end {
+					r.fix("trailing commas")
+					continue
+				}
+			case c == '"' || c == '\'' || c == '{' || c == '[' || c == '-' ||
+				c >= '0' && c <= '9' || isidentbyte(c):
+				r.fix("missing commas")
+			default:
+				return r.errorf("expected ',' or %q, found %q", end, c)
+			}
+			// the comma goes before the whitespace
+			r.out = append(r.out[:mark], append([]byte{','}, r.out[mark:]...)...)
+			if r.i == len(r.s) {
+				// a member was cut off after its comma
+				r.out = r.out[:mark]
+				r.fix("truncated document")
+				r.out = append(r.out, end)
+				return nil
+			}
+		}
+		if obj {
+			if err := r.key(); err != nil {
+				return err
+			}
+			r.ws()
+			if r.i == len(r.s) {
+				r.fix("truncated document")
+				r.out = append(r.out, ":null}"...)
+				return nil
+			}
+			if r.s[r.i] != ':' {
+				return r.errorf("expected ':', found %q", r.s[r.i])
+			}
+			r.out = append(r.out, ':')
+			r.i++
+		}
+		if err := r.value(); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *repairer) key() error {
+	c := r.s[r.i]
+	if c == '"' || c == '\'' {
+		r.str()
+		return nil
+	}
+	if !isidentbyte(c) {
+		return r.errorf("expected a key, found %q", c)
+	}
+	s := r.i
+	for r.i < len(r.s) && isidentbyte(r.s[r.i]) {
+		r.i++
+	}
+	r.fix("unquoted keys")
+	r.out = append(r.out, '"')
+	r.out = append(r.out, r.s[s:r.i]...)
+	r.out = append(r.out, '"')
+	return nil
+}
+
+func (r *repairer) str() {
+	q := r.s[r.i]
+	if q == '\'' {
+		r.fix("single quotes")
+	}
+	r.out = append(r.out, '"')
+	r.i++
+	for {
+		if r.i == len(r.s) {
+			r.fix("truncated document")
+			r.out = append(r.out, '"')
+			return
+		}
+		c := r.s[r.i]
+		switch {
+		case c == q:
+			r.out = append(r.out, '"')
+			r.i++
+			return
+		case c == '\\':
+			if r.i+1 == len(r.s) {
+				r.i++
+				continue
+			}
+			if r.s[r.i+1] == '\'' {
+				r.fix("escaped single quotes")
+				r.out = append(r.out, '\'')
+			} else {
+				r.out = append(r.out, c, r.s[r.i+1])
+			}
+			r.i += 2
+		case c == '"':
+			r.out = append(r.out, '\\', '"')
+			r.i++
+		case c < ' ':
+			r.fix("control characters in strings")
+			b, _ := gojson.Marshal(string(c))
+			r.out = append(r.out, b[1:len(b)-1]...)
+			r.i++
+		default:
+			r.out = append(r.out, c)
+			r.i++
+		}
+	}
+}
+
+func (r *repairer) number() error {
+	s := r.i
+	for r.i < len(r.s) && strings.IndexByte("+-.0123456789eE", r.s[r.i]) != -1 {
+		r.i++
+	}
+	// only the fixes that keep the value, like '+1' and '.5'
+	num := string(r.s[s:r.i])
+	fixed := strings.TrimPrefix(num, "+")
+	if strings.HasPrefix(fixed, ".") || strings.HasPrefix(fixed, "-.") {
+		fixed = strings.Replace(fixed, ".", "0.", 1)
+	}
+	if !gojson.Valid([]byte(fixed)) {
+		r.i = s
+		return r.errorf("invalid number %q", num)
+	}
+	if fixed != num {
+		r.fix("numbers")
+	}
+	r.out = append(r.out, fixed...)
+	return nil
+}
+
+func (r *repairer) word() error {
+	s := r.i
+	for r.i < len(r.s) && isidentbyte(r.s[r.i]) {
+		r.i++
+	}
+	w := string(r.s[s:r.i])
+	switch w {
+	case "true", "false", "null":
+	case "True", "False", "None":
+		r.fix("Python literals")
+		w = map[string]string{"True": "true", "False": "false", "None": "null"}[w]
+	default:
+		if r.i == len(r.s) {
+			for _, lit := range []string{"true", "false", "null"} {
+				if strings.HasPrefix(lit, w) {
+					r.fix("truncated document")
+					w = lit
+					break
+				}
+			}
+		}
+		if w != "true" && w != "false" && w != "null" {
+			r.i = s
+			return r.errorf("unexpected %q", w)
+		}
+	}
+	r.out = append(r.out, w...)
+	return nil
+}
+
+func isidentbyte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '$' || c >= 0x80
+}
+
+// startrepair opens the repair pane when the loaded json can't be parsed,
+// with the cursor at the error.
+func (e *Editor) startrepair() bool {
+	if e.format != formatJSON || len(bytes.TrimSpace(e.json)) == 0 || gojson.Valid(e.json) {
+		return false
+	}
+	e.panelines = nil
+	for _, line := range strings.Split(string(e.json), "\n") {
+		e.panelines = append(e.panelines, []rune(line))
+	}
+	e.panescrollx, e.panescrolly = 0, 0
+	e.panemode = true
+	e.repairmode = true
+	err := validerr(string(e.json))
+	if _, _, cerr := jsonctojson(e.json); erroff(cerr) >= erroff(err) {
+		// a JSON5 document is read up to what json can't hold, like
+		// Infinity, which tells more than the byte json rejects
+		err = cerr
+	}
+	e.repairmsg = err.Error()
+	e.panegoto(err)
+	return true
+}
+
+// erroff returns the offset of a parse error, or -1.
+func erroff(err error) int {
+	if perr, ok := err.(*parseerror); ok {
+		return perr.off
+	}
+	return -1
+}
+
+// panegoto moves the pane cursor to the position of a parse error.
+func (e *Editor) panegoto(err error) {
+	perr, ok := err.(*parseerror)
+	if !ok {
+		return
+	}
+	text := e.panetext()
+	off := perr.off
+	if off > len(text) {
+		off = len(text)
+	}
+	e.paney = strings.Count(text[:off], "\n")
+	e.panex = utf8.RuneCountInString(text[linestart([]byte(text), off):off])
+}
+
+// autofix repairs the text in the repair pane.
+func (e *Editor) autofix() {
+	text := e.panetext()
+	if gojson.Valid([]byte(text)) {
+		e.repairmsg = "nothing to fix, ^S to apply"
+		e.redraw()
+		return
+	}
+	fixed, fixes, err := repairjson([]byte(text))
+	if err != nil {
+		e.repairmsg = err.Error()
+		e.panegoto(err)
+		e.redraw()
+		return
+	}
+	e.panelines = nil
+	for _, line := range strings.Split(string(fixed), "\n") {
+		e.panelines = append(e.panelines, []rune(line))
+	}
+	if err := validerr(string(fixed)); err != nil {
+		e.repairmsg = err.Error()
+		e.panegoto(err)
+	} else {
+		e.repairmsg = "fixed " + strings.Join(fixes, ", ") + ", ^S to apply"
+		e.panemovey(0)
+	}
+	e.redraw()
+}
+
+// completerepair replaces the buffer with the repaired json. It differs
+// from the file, which isn't written until asked for.
+func (e *Editor) completerepair() {
+	text := e.panetext()
+	if err := validerr(text); err != nil {
+		e.repairmsg = err.Error()
+		e.panegoto(err)
+		e.redraw()
+		return
+	}
+	e.json = []byte(text)
+	// the broken file can't be compared with, so the changes that are
+	// reviewed before writing are the ones made after the repair
+	e.base = e.json
+	e.style = detectstyle(e.json)
+	e.undos, e.undoidx, e.undosize, e.savedidx = nil, 0, 0, -1
+	e.panemode = false
+	e.repairmode = false
+	e.panelines = nil
+	e.setpath("")
+	e.seterr(errors.New("repaired, ^O to write"))
+	e.editdirty = true
+	e.reflow()
+}
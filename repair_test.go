@@ -0,0 +1,78 @@
+package jd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		in    string
+		out   string
+		fixes []string
+	}{
+		{`{"a": 1}`, `{"a": 1}`, nil},
+		{"{\n  \"a\": 1, // one\n  // two\n  \"b\": /* three */ 2\n}", "{\n  \"a\": 1, \n  \"b\":  2\n}", []string{"comments"}},
+		{`[1, 2, ]`, `[1, 2 ]`, []string{"trailing commas"}},
+		{`{"a": 1,}`, `{"a": 1}`, []string{"trailing commas"}},
+		{`[1 2 "x"]`, `[1, 2, "x"]`, []string{"missing commas"}},
+		{`{'a': 'it\'s "x"'}`, `{"a": "it's \"x\""}`, []string{"single quotes", "escaped single quotes"}},
+		{`{a: 1, $b_2: true}`, `{"a": 1, "$b_2": true}`, []string{"unquoted keys"}},
+		{`[True, False, None]`, `[true, false, null]`, []string{"Python literals"}},
+		{"[\"a\tb\"]", `["a\tb"]`, []string{"control characters in strings"}},
+		{`[+1, .5, -.5, +.5e3]`, `[1, 0.5, -0.5, 0.5e3]`, []string{"numbers"}},
+		{"\ufeff[1]", `[1]`, nil},
+		// truncated documents
+		{`{"a": [1, {"b": "c`, `{"a": [1, {"b": "c"}]}`, []string{"truncated document"}},
+		{`{"a": 1, "b"`, `{"a": 1, "b":null}`, []string{"truncated document"}},
+		{`{"a":`, `{"a":null}`, []string{"truncated document"}},
+		{`[1, 2,`, `[1, 2]`, []string{"truncated document"}},
+		{`[tr`, `[true]`, []string{"truncated document"}},
+		{`[1 /* x`, `[1 ]`, []string{"comments", "truncated document"}},
+	}
+	for _, tt := range tests {
+		out, fixes, err := repairjson([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if string(out) != tt.out {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.in, out, tt.out)
+		}
+		if !reflect.DeepEqual(fixes, tt.fixes) {
+			t.Errorf("%q: fixes %q, want %q", tt.in, fixes, tt.fixes)
+		}
+	}
+}
+
+func TestRepairJSONErrors(t *testing.T) {
+	tests := []struct {
+		in        string
+		off       int
+		line, col int
+	}{
+		{`[-]`, 1, 1, 2},
+		{`[1e]`, 1, 1, 2},
+		{`[1.]`, 1, 1, 2},
+		{`{"a": 1.e5}`, 6, 1, 7},
+		{`[01]`, 1, 1, 2},
+		{"[1,\n  nope]", 6, 2, 3},
+		{`{"a" 1}`, 5, 1, 6},
+		{`{[1]: 2}`, 1, 1, 2},
+		{`[1] 2`, 4, 1, 5},
+		{`[1; 2]`, 2, 1, 3},
+		{`@`, 0, 1, 1},
+	}
+	for _, tt := range tests {
+		out, _, err := repairjson([]byte(tt.in))
+		perr, ok := err.(*parseerror)
+		if !ok {
+			t.Errorf("%q: expected a parse error, got %q, %v", tt.in, out, err)
+			continue
+		}
+		if perr.off != tt.off || perr.line != tt.line || perr.col != tt.col {
+			t.Errorf("%q: error at %d (%d:%d), want %d (%d:%d): %v",
+				tt.in, perr.off, perr.line, perr.col, tt.off, tt.line, tt.col, err)
+		}
+	}
+}
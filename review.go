@@ -0,0 +1,151 @@
+package jd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// startreview shows the changes that are about to be written. It tells
+// whether there's anything to review.
+func (e *Editor) startreview() bool {
+	if e.base == nil || !valid(string(e.base)) {
+		return false
+	}
+	e.review = changes(e.base, e.json)
+	if len(e.review) == 0 {
+		return false
+	}
+	e.reviewidx = 0
+	e.reviewmode = true
+	e.redraw()
+	return true
+}
+
+// dropchange undoes the selected change in the buffer.
+func (e *Editor) dropchange() {
+	if len(e.review) == 0 {
+		return
+	}
+	c := e.review[e.reviewidx]
+	njson, err := patch(e.json, c.path, e.base, c.old, c.op == '-')
+	if err != nil {
+		e.seterr(err)
+		e.redraw()
+		return
+	}
+	e.commit(c.path, njson)
+	e.review = changes(e.base, e.json)
+	if e.reviewidx >= len(e.review) {
+		e.reviewidx = len(e.review) - 1
+	}
+	if e.reviewidx < 0 {
+		e.reviewidx = 0
+	}
+	e.seterr(errors.New("dropped"))
+	e.reflow()
+}
+
+// reviewkey handles a key in the review of the changes.
+func (e *Editor) reviewkey(ev termbox.Event) {
+	switch ev.Key {
+	default:
+		if ev.Ch == 0 && ev.Key == 3 {
+			// Ctrl-C, back to editing
+			e.reviewmode = false
+			e.cancelwrite()
+			return
+		}
+		if ev.Ch == 0 && ev.Key == 4 {
+			// Ctrl-D, drop the change
+			e.dropchange()
+			return
+		}
+	case termbox.KeyEsc:
+		e.reviewmode = false
+		e.cancelwrite()
+		return
+	case termbox.KeyEnter:
+		e.reviewmode = false
+		e.reviewed = true
+		e.redraw()
+		e.completewrite()
+		return
+	case termbox.KeyArrowUp:
+		e.reviewidx--
+	case termbox.KeyArrowDown:
+		e.reviewidx++
+	case termbox.KeyPgup:
+		e.reviewidx -= e.h / 2
+	case termbox.KeyPgdn:
+		e.reviewidx += e.h / 2
+	}
+	if e.reviewidx > len(e.review)-1 {
+		e.reviewidx = len(e.review) - 1
+	}
+	if e.reviewidx < 0 {
+		e.reviewidx = 0
+	}
+	e.redraw()
+}
+
+// blitreview draws the list of changes in place of the result, scrolled
+// so the selected one can be seen.
+func (e *Editor) blitreview() {
+	e.resy = e.y
+	vislines := e.h - e.resy - 2
+	if len(e.review) == 0 {
+		text := "no changes"
+		for x, c := range text {
+			termbox.SetCell(x, e.resy, c, termbox.Attribute(gray), termbox.ColorDefault)
+		}
+		return
+	}
+	top := 0
+	if e.reviewidx >= vislines {
+		top = e.reviewidx - vislines + 1
+	}
+	for i := 0; i < vislines && top+i < len(e.review); i++ {
+		c := e.review[top+i]
+		path := c.path
+		if path == "" {
+			path = "(root)"
+		}
+		var text string
+		fg := termbox.Attribute(lightGray)
+		switch c.op {
+		case '+':
+			text = fmt.Sprintf("+ %s: %s", path, compact([]byte(c.new.Raw)))
+			fg = termbox.ColorGreen
+		case '-':
+			text = fmt.Sprintf("- %s: %s", path, compact([]byte(c.old.Raw)))
+			fg = termbox.ColorRed
+		default:
+			text = fmt.Sprintf("~ %s: %s → %s", path, compact([]byte(c.old.Raw)), compact([]byte(c.new.Raw)))
+			fg = termbox.ColorYellow
+		}
+		bg := termbox.ColorDefault
+		if top+i == e.reviewidx {
+			fg, bg = termbox.ColorBlack, fg
+		}
+		x := 0
+		for _, r := range text {
+			if x == e.w {
+				break
+			}
+			termbox.SetCell(x, e.resy+i, r, fg, bg)
+			x++
+		}
+		for ; top+i == e.reviewidx && x < e.w; x++ {
+			termbox.SetCell(x, e.resy+i, ' ', fg, bg)
+		}
+	}
+}
+
+func (e *Editor) reviewnotice() string {
+	if len(e.review) == 1 {
+		return "1 change, Enter to write"
+	}
+	return fmt.Sprintf("%d changes, Enter to write", len(e.review))
+}
@@ -0,0 +1,122 @@
+package jd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteFile is like ioutil.WriteFile but writes data to a temporary file
+// that is synced and then renamed over filename, so that a crash never
+// leaves a partially written file behind. A symlink is followed and the
+// file it points to is replaced. An existing file keeps its mode and owner,
+// and perm is only used for new files.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return savefile(filename, data, perm, false)
+}
+
+func savefile(path string, data []byte, perm os.FileMode, backup bool) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	fi, err := os.Stat(path)
+	exists := err == nil
+	if exists {
+		perm = fi.Mode()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm.Perm()); err != nil {
+		return err
+	}
+	if exists {
+		// changing the owner needs privileges that we may not have, in
+		// which case the file ends up owned by the current user.
+		chown(tmp, fi)
+		if backup {
+			if err := copyfile(path+".bak", path, perm.Perm()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func copyfile(dst, src string, perm os.FileMode) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, perm)
+}
+
+// filestamp identifies the content of a file at load time, so that a write
+// can tell whether someone else changed the file in the meantime.
+type filestamp struct {
+	path    string
+	modtime time.Time
+	size    int64
+	hash    string
+}
+
+func stampfile(path string, data []byte) filestamp {
+	st := filestamp{path: path, size: -1, hash: contenthash(data)}
+	if fi, err := os.Stat(path); err == nil {
+		st.modtime, st.size = fi.ModTime(), fi.Size()
+	}
+	return st
+}
+
+// changed tells whether the file at the stamp path no longer holds the
+// content it was stamped with.
+func (st filestamp) changed() bool {
+	fi, err := os.Stat(st.path)
+	if err != nil {
+		return st.size != -1
+	}
+	if fi.ModTime().Equal(st.modtime) && fi.Size() == st.size {
+		return false
+	}
+	b, err := ioutil.ReadFile(st.path)
+	return err != nil || contenthash(b) != st.hash
+}
+
+var errChanged = errors.New("file changed on disk since it was loaded")
+
+// samefile tells whether a and b are the same file, following symlinks. A
+// file that doesn't exist, like one deleted since it was loaded, is only
+// the same as its own path.
+func samefile(a, b string) bool {
+	fa, erra := os.Stat(a)
+	fb, errb := os.Stat(b)
+	if erra != nil || errb != nil {
+		absa, erra := filepath.Abs(a)
+		absb, errb := filepath.Abs(b)
+		return erra == nil && errb == nil && absa == absb
+	}
+	return os.SameFile(fa, fb)
+}
@@ -0,0 +1,726 @@
+package jd
+
+import (
+	gojson "encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	schemaColor = 0xc4 + 1 | termbox.AttrUnderline | termbox.AttrBold
+	schemaBar   = 0xa0 + 1
+
+	// maxSchemaErrors is the most errors that are kept for a document.
+	maxSchemaErrors = 1000
+	// maxSchemaDepth stops a schema that refers to itself from recursing
+	// forever.
+	maxSchemaDepth = 200
+)
+
+// schema is a JSON Schema that the buffer is validated against. It's read
+// from local files only, and the files that are referred to by a $ref are
+// loaded as they are needed.
+type schema struct {
+	file    string                    // the file of the root schema
+	docs    map[string]gjson.Result   // the schema files that are loaded
+	regexps map[string]*regexp.Regexp // the compiled patterns, nil if invalid
+}
+
+// schemaerr is a value that doesn't match the schema.
+type schemaerr struct {
+	path   string // the path of the value
+	pos, n int    // the part of the formatted json that is marked
+	msg    string
+}
+
+func loadschema(file string) (*schema, error) {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	s := &schema{
+		file:    file,
+		docs:    make(map[string]gjson.Result),
+		regexps: make(map[string]*regexp.Regexp),
+	}
+	if _, err := s.doc(file); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// doc returns the schema file at path.
+func (s *schema) doc(path string) (gjson.Result, error) {
+	if doc, ok := s.docs[path]; ok {
+		return doc, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	if !gojson.Valid(b) {
+		return gjson.Result{}, fmt.Errorf("%s: not valid json", path)
+	}
+	doc := gjson.ParseBytes(b)
+	s.docs[path] = doc
+	return doc, nil
+}
+
+// resolve returns the schema that ref refers to from the schema file at
+// file, and the file it's in.
+func (s *schema) resolve(file, ref string) (string, gjson.Result, error) {
+	loc, frag := ref, ""
+	if i := strings.IndexByte(ref, '#'); i != -1 {
+		loc, frag = ref[:i], ref[i+1:]
+	}
+	if strings.Contains(loc, "://") && !strings.HasPrefix(loc, "file://") {
+		return "", gjson.Result{}, fmt.Errorf("$ref %q is not a local file", ref)
+	}
+	loc = strings.TrimPrefix(loc, "file://")
+	if loc != "" {
+		if !filepath.IsAbs(loc) {
+			loc = filepath.Join(filepath.Dir(file), loc)
+		}
+		file = loc
+	}
+	node, err := s.doc(file)
+	if err != nil {
+		return "", gjson.Result{}, err
+	}
+	if frag == "" {
+		return file, node, nil
+	}
+	if !strings.HasPrefix(frag, "/") {
+		return "", gjson.Result{}, fmt.Errorf("$ref %q is not a json pointer", ref)
+	}
+	for _, tok := range strings.Split(frag[1:], "/") {
+		if t, err := url.PathUnescape(tok); err == nil {
+			tok = t
+		}
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		node = node.Get(joinpath("", tok))
+		if !node.Exists() {
+			return "", gjson.Result{}, fmt.Errorf("$ref %q not found", ref)
+		}
+	}
+	return file, node, nil
+}
+
+func (s *schema) regexp(pattern string) *regexp.Regexp {
+	re, ok := s.regexps[pattern]
+	if !ok {
+		// patterns that Go can't compile are skipped
+		re, _ = regexp.Compile(pattern)
+		s.regexps[pattern] = re
+	}
+	return re
+}
+
+// validate returns the errors of the document root, in document order.
+// The records of a JSON Lines file are each validated on their own.
+func (s *schema) validate(root gjson.Result, records bool) []schemaerr {
+	v := &validator{s: s}
+	doc, _ := s.doc(s.file)
+	if records && isarray(root) {
+		members(jsonvalue{val: root}, func(in jsonvalue) bool {
+			v.check(s.file, doc, in)
+			return len(v.errs) < maxSchemaErrors
+		})
+	} else {
+		v.check(s.file, doc, jsonvalue{val: root})
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		return v.errs[i].pos < v.errs[j].pos
+	})
+	return v.errs
+}
+
+// jsonvalue is a value of the document with its position in it.
+type jsonvalue struct {
+	val  gjson.Result // with the Index in the whole document
+	key  gjson.Result // the key of an object member
+	path string
+}
+
+// members calls fn with each member of an object or array.
+func members(in jsonvalue, fn func(in jsonvalue) bool) {
+	var i int
+	obj := isobject(in.val)
+	in.val.ForEach(func(key, val gjson.Result) bool {
+		val.Index += in.val.Index
+		m := jsonvalue{val: val}
+		if obj {
+			key.Index += in.val.Index
+			m.key = key
+			m.path = joinpath(in.path, key.String())
+		} else {
+			m.path = joinpath(in.path, strconv.Itoa(i))
+		}
+		i++
+		return fn(m)
+	})
+}
+
+type validator struct {
+	s     *schema
+	errs  []schemaerr
+	depth int
+}
+
+// fail adds an error for the value. The key of a member is marked, or
+// the opening of an object or array, so the marks of the values inside
+// can still be seen.
+func (v *validator) fail(in jsonvalue, format string, args ...interface{}) {
+	if len(v.errs) >= maxSchemaErrors {
+		return
+	}
+	err := schemaerr{path: in.path, pos: in.val.Index, n: len(in.val.Raw)}
+	if in.key.Exists() {
+		err.pos, err.n = in.key.Index, len(in.key.Raw)
+	} else if in.val.Type == gjson.JSON {
+		err.n = 1
+	}
+	err.msg = fmt.Sprintf(format, args...)
+	v.errs = append(v.errs, err)
+}
+
+// matches tells whether the value matches the schema, without adding any
+// errors.
+func (v *validator) matches(file string, sch gjson.Result, in jsonvalue) bool {
+	sub := &validator{s: v.s, depth: v.depth}
+	return sub.check(file, sch, in)
+}
+
+// check validates the value against the schema sch from the schema file
+// at file, and tells whether it's valid.
+func (v *validator) check(file string, sch gjson.Result, in jsonvalue) bool {
+	switch sch.Type {
+	case gjson.True:
+		return true
+	case gjson.False:
+		v.fail(in, "not allowed")
+		return false
+	}
+	if !isobject(sch) || v.depth > maxSchemaDepth {
+		return true
+	}
+	v.depth++
+	defer func() { v.depth-- }()
+	n := len(v.errs)
+	valid := true
+	if ref := sch.Get("$ref"); ref.Exists() {
+		rfile, rsch, err := v.s.resolve(file, ref.String())
+		if err != nil {
+			v.fail(in, "schema: %v", err)
+			return false
+		}
+		valid = v.check(rfile, rsch, in) && valid
+	}
+	if t := sch.Get("type"); t.Exists() {
+		var types []string
+		for _, t := range t.Array() {
+			types = append(types, t.String())
+		}
+		if !hastype(in.val, types) {
+			v.fail(in, "expected %s, found %s", strings.Join(types, " or "), jsontype(in.val))
+			return false
+		}
+	}
+	if enum := sch.Get("enum"); isarray(enum) {
+		var ok bool
+		for _, el := range enum.Array() {
+			if jsonequal(in.val, el) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			v.fail(in, "must be one of %s", rawlist(enum.Array()))
+		}
+	}
+	if c := sch.Get("const"); c.Exists() && !jsonequal(in.val, c) {
+		v.fail(in, "must be %s", c.Raw)
+	}
+	switch in.val.Type {
+	case gjson.Number:
+		v.number(sch, in)
+	case gjson.String:
+		v.string(sch, in)
+	case gjson.JSON:
+		if isarray(in.val) {
+			v.array(file, sch, in)
+		} else {
+			v.object(file, sch, in)
+		}
+	}
+	for _, sub := range sch.Get("allOf").Array() {
+		v.check(file, sub, in)
+	}
+	if anyOf := sch.Get("anyOf"); isarray(anyOf) {
+		var ok bool
+		for _, sub := range anyOf.Array() {
+			if v.matches(file, sub, in) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			v.fail(in, "doesn't match any of the schemas in anyOf")
+		}
+	}
+	if oneOf := sch.Get("oneOf"); isarray(oneOf) {
+		var count int
+		for _, sub := range oneOf.Array() {
+			if v.matches(file, sub, in) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(in, "matches %d of the schemas in oneOf, not exactly one", count)
+		}
+	}
+	if not := sch.Get("not"); not.Exists() && v.matches(file, not, in) {
+		v.fail(in, "must not match the schema in not")
+	}
+	if cond := sch.Get("if"); cond.Exists() {
+		if v.matches(file, cond, in) {
+			if then := sch.Get("then"); then.Exists() {
+				v.check(file, then, in)
+			}
+		} else if els := sch.Get("else"); els.Exists() {
+			v.check(file, els, in)
+		}
+	}
+	return valid && len(v.errs) == n
+}
+
+func (v *validator) number(sch gjson.Result, in jsonvalue) {
+	x := in.val.Float()
+	if min := sch.Get("minimum"); min.Exists() {
+		if sch.Get("exclusiveMinimum").Type == gjson.True {
+			if x <= min.Float() {
+				v.fail(in, "must be greater than %s", min.Raw)
+			}
+		} else if x < min.Float() {
+			v.fail(in, "must be at least %s", min.Raw)
+		}
+	}
+	if max := sch.Get("maximum"); max.Exists() {
+		if sch.Get("exclusiveMaximum").Type == gjson.True {
+			if x >= max.Float() {
+				v.fail(in, "must be less than %s", max.Raw)
+			}
+		} else if x > max.Float() {
+			v.fail(in, "must be at most %s", max.Raw)
+		}
+	}
+	if min := sch.Get("exclusiveMinimum"); min.Type == gjson.Number && x <= min.Float() {
+		v.fail(in, "must be greater than %s", min.Raw)
+	}
+	if max := sch.Get("exclusiveMaximum"); max.Type == gjson.Number && x >= max.Float() {
+		v.fail(in, "must be less than %s", max.Raw)
+	}
+	if m := sch.Get("multipleOf"); m.Type == gjson.Number && m.Float() > 0 {
+		q := x / m.Float()
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(in, "must be a multiple of %s", m.Raw)
+		}
+	}
+}
+
+func (v *validator) string(sch gjson.Result, in jsonvalue) {
+	n := utf8.RuneCountInString(in.val.Str)
+	if min := sch.Get("minLength"); min.Exists() && n < int(min.Int()) {
+		v.fail(in, "shorter than %d characters", min.Int())
+	}
+	if max := sch.Get("maxLength"); max.Exists() && n > int(max.Int()) {
+		v.fail(in, "longer than %d characters", max.Int())
+	}
+	if p := sch.Get("pattern"); p.Exists() {
+		if re := v.s.regexp(p.String()); re != nil && !re.MatchString(in.val.Str) {
+			v.fail(in, "doesn't match the pattern %s", p.String())
+		}
+	}
+}
+
+func (v *validator) array(file string, sch gjson.Result, in jsonvalue) {
+	var els []jsonvalue
+	members(in, func(el jsonvalue) bool {
+		els = append(els, el)
+		return true
+	})
+	// the schemas of the first elements, and then of the rest
+	prefix := sch.Get("prefixItems").Array()
+	rest := sch.Get("items")
+	if isarray(rest) {
+		prefix, rest = rest.Array(), sch.Get("additionalItems")
+	}
+	for i, el := range els {
+		if i < len(prefix) {
+			v.check(file, prefix[i], el)
+		} else if rest.Exists() {
+			v.check(file, rest, el)
+		}
+	}
+	if min := sch.Get("minItems"); min.Exists() && len(els) < int(min.Int()) {
+		v.fail(in, "fewer than %d items", min.Int())
+	}
+	if max := sch.Get("maxItems"); max.Exists() && len(els) > int(max.Int()) {
+		v.fail(in, "more than %d items", max.Int())
+	}
+	if sch.Get("uniqueItems").Type == gjson.True {
+	dups:
+		for i := range els {
+			for j := 0; j < i; j++ {
+				if jsonequal(els[i].val, els[j].val) {
+					v.fail(els[i], "the same as item %d", j)
+					break dups
+				}
+			}
+		}
+	}
+	if contains := sch.Get("contains"); contains.Exists() {
+		var count int
+		for _, el := range els {
+			if v.matches(file, contains, el) {
+				count++
+			}
+		}
+		min, max := sch.Get("minContains"), sch.Get("maxContains")
+		switch {
+		case !min.Exists() && count == 0:
+			v.fail(in, "no item matches the schema in contains")
+		case min.Exists() && count < int(min.Int()):
+			v.fail(in, "fewer than %d items match the schema in contains", min.Int())
+		case max.Exists() && count > int(max.Int()):
+			v.fail(in, "more than %d items match the schema in contains", max.Int())
+		}
+	}
+}
+
+func (v *validator) object(file string, sch gjson.Result, in jsonvalue) {
+	props := sch.Get("properties")
+	patterns := sch.Get("patternProperties")
+	extra := sch.Get("additionalProperties")
+	names := sch.Get("propertyNames")
+	has := make(map[string]bool)
+	members(in, func(m jsonvalue) bool {
+		name := m.key.String()
+		has[name] = true
+		matched := false
+		if prop := props.Get(joinpath("", name)); isobject(props) && prop.Exists() {
+			matched = true
+			v.check(file, prop, m)
+		}
+		patterns.ForEach(func(pattern, psch gjson.Result) bool {
+			if re := v.s.regexp(pattern.String()); re != nil && re.MatchString(name) {
+				matched = true
+				v.check(file, psch, m)
+			}
+			return true
+		})
+		if !matched && extra.Exists() {
+			if extra.Type == gjson.False {
+				v.fail(m, "%s is not allowed", m.key.Raw)
+			} else {
+				v.check(file, extra, m)
+			}
+		}
+		if names.Exists() {
+			key := jsonvalue{val: m.key, path: m.path}
+			if !v.matches(file, names, key) {
+				v.fail(m, "the name %s is not allowed", m.key.Raw)
+			}
+		}
+		return true
+	})
+	for _, req := range sch.Get("required").Array() {
+		if req.Type == gjson.String && !has[req.String()] {
+			v.fail(in, "missing %s", req.Raw)
+		}
+	}
+	if min := sch.Get("minProperties"); min.Exists() && len(has) < int(min.Int()) {
+		v.fail(in, "fewer than %d properties", min.Int())
+	}
+	if max := sch.Get("maxProperties"); max.Exists() && len(has) > int(max.Int()) {
+		v.fail(in, "more than %d properties", max.Int())
+	}
+	deps := func(name string, dep gjson.Result) {
+		if !has[name] {
+			return
+		}
+		if isarray(dep) {
+			for _, req := range dep.Array() {
+				if !has[req.String()] {
+					v.fail(in, "%q needs %s", name, req.Raw)
+				}
+			}
+		} else {
+			v.check(file, dep, in)
+		}
+	}
+	for _, kw := range []string{"dependencies", "dependentRequired", "dependentSchemas"} {
+		sch.Get(kw).ForEach(func(name, dep gjson.Result) bool {
+			deps(name.String(), dep)
+			return true
+		})
+	}
+}
+
+func isarray(val gjson.Result) bool {
+	return val.Type == gjson.JSON && strings.TrimLeft(val.Raw, " \t\r\n")[0] == '['
+}
+
+func isobject(val gjson.Result) bool {
+	return val.Type == gjson.JSON && strings.TrimLeft(val.Raw, " \t\r\n")[0] == '{'
+}
+
+// hastype tells whether the value is of one of the JSON Schema types.
+func hastype(val gjson.Result, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "integer":
+			if val.Type == gjson.Number && val.Float() == math.Trunc(val.Float()) {
+				return true
+			}
+		case "number":
+			if val.Type == gjson.Number {
+				return true
+			}
+		default:
+			if jsontype(val) == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsontype returns the JSON Schema type of the value.
+func jsontype(val gjson.Result) string {
+	switch val.Type {
+	case gjson.Null:
+		return "null"
+	case gjson.True, gjson.False:
+		return "boolean"
+	case gjson.Number:
+		return "number"
+	case gjson.String:
+		return "string"
+	}
+	if isarray(val) {
+		return "array"
+	}
+	return "object"
+}
+
+// jsonequal tells whether a and b are the same json, ignoring the order of
+// keys and how numbers are written.
+func jsonequal(a, b gjson.Result) bool {
+	if a.Type == gjson.Number && b.Type == gjson.Number {
+		return a.Float() == b.Float()
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Type != gjson.JSON {
+		return a.String() == b.String()
+	}
+	if isarray(a) != isarray(b) {
+		return false
+	}
+	if isarray(a) {
+		aels, bels := a.Array(), b.Array()
+		if len(aels) != len(bels) {
+			return false
+		}
+		for i := range aels {
+			if !jsonequal(aels[i], bels[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	amap, bmap := a.Map(), b.Map()
+	if len(amap) != len(bmap) {
+		return false
+	}
+	for key, aval := range amap {
+		if bval, ok := bmap[key]; !ok || !jsonequal(aval, bval) {
+			return false
+		}
+	}
+	return true
+}
+
+// rawlist returns the values as a list for a message.
+func rawlist(vals []gjson.Result) string {
+	var raws []string
+	for i, val := range vals {
+		if i == 5 {
+			raws = append(raws, "...")
+			break
+		}
+		raws = append(raws, string(compact([]byte(val.Raw))))
+	}
+	return strings.Join(raws, ", ")
+}
+
+// openschema loads the schema of the buffer from the file at path, or else
+// from the $schema key of the document when that's a local file.
+func (e *Editor) openschema(path string) error {
+	if path == "" {
+		ref := gjson.GetBytes(e.json, "$schema")
+		if ref.Type != gjson.String {
+			return nil
+		}
+		path = ref.String()
+		if strings.Contains(path, "://") && !strings.HasPrefix(path, "file://") {
+			// the usual URL of a draft, or a schema that can't be fetched
+			return nil
+		}
+		path = strings.TrimPrefix(path, "file://")
+		if !filepath.IsAbs(path) && e.stamp.path != "" {
+			path = filepath.Join(filepath.Dir(e.stamp.path), path)
+		}
+	}
+	s, err := loadschema(path)
+	if err != nil {
+		return fmt.Errorf("schema: %v", err)
+	}
+	e.schema = s
+	return nil
+}
+
+// validate checks the formatted json against the schema.
+func (e *Editor) validate() {
+	e.schemaerrs = nil
+	if e.schema != nil {
+		e.schemaerrs = e.schema.validate(e.root, e.format == formatNDJSON && !e.recview)
+	}
+}
+
+// schemamsg returns the errors at the selected path.
+func (e *Editor) schemamsg() string {
+	if e.invalid || e.editmode {
+		return ""
+	}
+	var msgs []string
+	for _, err := range e.schemaerrs {
+		if err.path == e.path {
+			msgs = append(msgs, err.msg)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// nexterror selects the next value that doesn't match the schema.
+func (e *Editor) nexterror() {
+	if len(e.schemaerrs) == 0 {
+		if e.schema == nil {
+			e.seterr(errors.New("no schema"))
+		} else {
+			e.seterr(errors.New("no schema errors"))
+		}
+		e.redraw()
+		return
+	}
+	cur := -1
+	if !e.invalid && e.path != "" {
+		cur = e.result.Index
+	}
+	var idx int
+	for i, err := range e.schemaerrs {
+		if err.pos > cur && err.path != e.path {
+			idx = i
+			break
+		}
+	}
+	err := e.schemaerrs[idx]
+	e.path = err.path
+	e.pidx = len(e.path)
+	e.hintline = 0
+	e.seterr(fmt.Errorf("error %d of %d", idx+1, len(e.schemaerrs)))
+	e.exec()
+	e.redraw()
+}
+
+// blitmarked draws the formatted json from s to end with the values that
+// don't match the schema marked.
+func (e *Editor) blitmarked(s, end int) {
+	fg := e.fg
+	for _, err := range e.schemaerrs {
+		a, b := err.pos, err.pos+err.n
+		if b <= s || a < s && b > s {
+			// before, or already marked by an error at the same place
+			continue
+		}
+		if a >= end {
+			break
+		}
+		if b > end {
+			b = end
+		}
+		e.blitstr(e.blitroot(s, a))
+		e.fg = schemaColor
+		e.blitstr(e.blitroot(a, b))
+		e.fg = fg
+		s = b
+	}
+	e.blitstr(e.blitroot(s, end))
+}
+
+// markspans adds the marks of the schema errors to the spans of large-file
+// mode. The spans that are there already take precedence.
+func (e *Editor) markspans(spans []lazyspan) []lazyspan {
+	n := len(spans)
+	var last int
+	for _, err := range e.schemaerrs {
+		a, b := err.pos, err.pos+err.n
+		if a < last {
+			continue
+		}
+		var overlaps bool
+		for _, span := range spans[:n] {
+			overlaps = overlaps || a < span.end && b > span.start
+		}
+		if !overlaps {
+			spans = append(spans, lazyspan{a, b, schemaColor})
+			last = b
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	return spans
+}
+
+// blitschemamsg draws the errors at the selected path at the end of the
+// status bar on line y, or that the selected hint is a new key.
+func (e *Editor) blitschemamsg(y int) {
+	msg := e.schemamsg()
+	fg, bg := termbox.ColorWhite|termbox.AttrBold, termbox.Attribute(schemaBar)
+	if hkey, ok := e.curhint(); ok && hkey.new && e.invalid && !e.editmode {
+		msg, fg, bg = "new key", hintColor, e.bg
+	}
+	if msg == "" {
+		return
+	}
+	text := []rune(" " + msg + " ")
+	x := e.w - len(text)
+	if x < 0 {
+		text, x = text[-x:], 0
+	}
+	for i, c := range text {
+		termbox.SetCell(x+i, y, c, fg, bg)
+	}
+}
@@ -0,0 +1,108 @@
+package jd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		schema string
+		files  map[string]string // other schema files, by name
+		json   string
+		errs   []string // the paths of the values that don't match
+	}{
+		{`{"type":"object"}`, nil, `{}`, nil},
+		{`{"type":"object"}`, nil, `[]`, []string{""}},
+		{`{"type":["string","null"]}`, nil, `null`, nil},
+		{`{"properties":{"a":{"type":"integer"},"b":{"minimum":2}}}`, nil,
+			`{"a":1.5,"b":1,"c":true}`, []string{"a", "b"}},
+		{`{"required":["a","b"],"additionalProperties":false,"properties":{"a":{}}}`, nil,
+			`{"a":1,"x":2}`, []string{"", "x"}},
+		{`{"patternProperties":{"^n_":{"type":"number"}}}`, nil,
+			`{"n_1":1,"n_2":"x","s":"y"}`, []string{"n_2"}},
+		{`{"items":{"type":"string","maxLength":2},"minItems":1}`, nil,
+			`["ab","abc",3]`, []string{"1", "2"}},
+		{`{"prefixItems":[{"const":1}],"items":false}`, nil, `[1,2]`, []string{"1"}},
+		{`{"enum":["a",{"b":[1]}]}`, nil, `{"b":[1.0]}`, nil},
+		{`{"pattern":"^[a-z]+$"}`, nil, `"aB"`, []string{""}},
+		// oneOf, anyOf, allOf, not and if
+		{`{"oneOf":[{"type":"integer"},{"minimum":0}]}`, nil, `1`, []string{""}},
+		{`{"oneOf":[{"type":"integer"},{"minimum":0}]}`, nil, `1.5`, nil},
+		{`{"oneOf":[{"type":"integer"},{"minimum":0}]}`, nil, `-1.5`, []string{""}},
+		{`{"anyOf":[{"type":"string"},{"type":"null"}]}`, nil, `1`, []string{""}},
+		{`{"allOf":[{"minimum":1},{"maximum":2}]}`, nil, `3`, []string{""}},
+		{`{"not":{"type":"null"}}`, nil, `null`, []string{""}},
+		{`{"if":{"properties":{"t":{"const":"n"}}},"then":{"properties":{"v":{"type":"number"}}},"else":{"properties":{"v":{"type":"string"}}}}`, nil,
+			`[{"t":"n","v":"x"},{"t":"s","v":"x"}]`, nil},
+		// uniqueItems
+		{`{"uniqueItems":true}`, nil, `[1,"1",{"a":1,"b":2}]`, nil},
+		{`{"uniqueItems":true}`, nil, `[1,2,1.0]`, []string{"2"}},
+		{`{"uniqueItems":true}`, nil, `[{"a":1,"b":2},{"b":2,"a":1}]`, []string{"1"}},
+		// $ref in the same file and to other files
+		{`{"$defs":{"n":{"type":"number"}},"properties":{"a":{"$ref":"#/$defs/n"}}}`, nil,
+			`{"a":"x"}`, []string{"a"}},
+		{`{"properties":{"a":{"$ref":"other.json"}}}`,
+			map[string]string{"other.json": `{"type":"string"}`},
+			`{"a":1}`, []string{"a"}},
+		{`{"items":{"$ref":"sub/defs.json#/$defs/item"}}`,
+			map[string]string{"sub/defs.json": `{"$defs":{"item":{"properties":{"x":{"$ref":"#/$defs/x"}}},"x":{"type":"boolean"}}}`},
+			`[{"x":true},{"x":1}]`, []string{"1.x"}},
+		{`{"properties":{"a":{"$ref":"missing.json"}}}`, nil, `{"a":1}`, []string{"a"}},
+		// a schema that refers to itself
+		{`{"properties":{"next":{"$ref":"#"},"v":{"type":"number"}}}`, nil,
+			`{"next":{"next":{"v":"x"}}}`, []string{"next.next.v"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		files := map[string]string{"schema.json": tt.schema}
+		for name, s := range tt.files {
+			files[name] = s
+		}
+		for name, s := range files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s, err := loadschema(filepath.Join(dir, "schema.json"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.schema, err)
+			continue
+		}
+		var got []string
+		for _, err := range s.validate(gjson.Parse(tt.json), false) {
+			got = append(got, err.path)
+		}
+		if !reflect.DeepEqual(got, tt.errs) {
+			t.Errorf("%s\n%s: errors at %q, want %q", tt.schema, tt.json, got, tt.errs)
+		}
+	}
+}
+
+func TestSchemaValidateRecords(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "schema.json")
+	if err := ioutil.WriteFile(file, []byte(`{"required":["id"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadschema(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, err := range s.validate(gjson.Parse(`[{"id":1},{},{"id":2},{}]`), true) {
+		got = append(got, err.path)
+	}
+	if want := []string{"1", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("errors at %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package jd
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tidwall/gjson"
+)
+
+// writestyle is the layout of a json file when it's written.
+type writestyle struct {
+	layout  int    // styleKeep, styleCompact or stylePretty
+	indent  string // the indent of stylePretty
+	sorted  bool   // sort the keys of objects
+	newline bool   // end the file with a newline
+}
+
+const (
+	styleKeep    = iota // the json as it was edited
+	styleCompact        // no whitespace at all
+	stylePretty         // a member per line
+)
+
+// detectstyle returns the style of the json file b. A file is only laid
+// out again on write when it was already in that layout, otherwise it's
+// kept as it is, but the indent that was found is still the default for
+// pretty printing.
+func detectstyle(b []byte) writestyle {
+	s := writestyle{indent: "  ", newline: bytes.HasSuffix(b, []byte{'\n'})}
+	json := bytes.TrimSpace(b)
+	if len(json) == 0 {
+		return s
+	}
+	lines := bytes.Split(json, []byte{'\n'})
+	for _, line := range lines {
+		ind := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+		if len(ind) > 0 && len(ind) < len(line) {
+			s.indent = string(ind)
+			break
+		}
+	}
+	if len(json) > largeSize {
+		return s
+	}
+	if len(lines) == 1 && bytes.Equal(json, compact(json)) {
+		s.layout = styleCompact
+	} else if bytes.Equal(json, prettyindent(json, s.indent)) {
+		s.layout = stylePretty
+	}
+	return s
+}
+
+// apply lays out json in the style.
+func (s writestyle) apply(json []byte) []byte {
+	layout := s.layout
+	if s.sorted {
+		json = sortkeys(json)
+		if layout == styleKeep {
+			layout = stylePretty
+		}
+	}
+	switch layout {
+	case styleCompact:
+		json = compact(json)
+	case stylePretty:
+		json = prettyindent(json, s.indent)
+	}
+	json = bytes.TrimRight(json, " \t\r\n")
+	if s.newline {
+		json = append(json[:len(json):len(json)], '\n')
+	}
+	return json
+}
+
+// nextlayout returns the style with the layout that follows in the write
+// prompt: keep, compact, then pretty with tabs, 2 spaces and 4 spaces.
+func (s writestyle) nextlayout() writestyle {
+	switch {
+	case s.layout == styleKeep:
+		s.layout = styleCompact
+	case s.layout == styleCompact:
+		s.layout, s.indent = stylePretty, "\t"
+	case s.indent == "\t":
+		s.indent = "  "
+	case s.indent == "  ":
+		s.indent = "    "
+	default:
+		s.layout = styleKeep
+	}
+	return s
+}
+
+func (s writestyle) String() string {
+	var parts []string
+	switch {
+	case s.layout == styleKeep:
+		parts = append(parts, "keep")
+	case s.layout == styleCompact:
+		parts = append(parts, "compact")
+	case strings.Trim(s.indent, "\t") == "":
+		parts = append(parts, "tabs")
+	default:
+		parts = append(parts, strconv.Itoa(len(s.indent))+" spaces")
+	}
+	if s.sorted {
+		parts = append(parts, "sorted")
+	}
+	if s.newline {
+		parts = append(parts, "newline")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sortkeys returns json with the keys of every object in order.
+func sortkeys(json []byte) []byte {
+	return appendsorted(nil, gjson.ParseBytes(json))
+}
+
+func appendsorted(b []byte, v gjson.Result) []byte {
+	if v.Type != gjson.JSON {
+		return append(b, v.Raw...)
+	}
+	var keys, vals []gjson.Result
+	v.ForEach(func(key, val gjson.Result) bool {
+		keys = append(keys, key)
+		vals = append(vals, val)
+		return true
+	})
+	idx := make([]int, len(vals))
+	for i := range idx {
+		idx[i] = i
+	}
+	obj := v.Raw[0] == '{'
+	if obj {
+		sort.SliceStable(idx, func(i, j int) bool {
+			return keys[idx[i]].String() < keys[idx[j]].String()
+		})
+	}
+	b = append(b, v.Raw[0])
+	for n, i := range idx {
+		if n > 0 {
+			b = append(b, ',')
+		}
+		if obj {
+			b = append(b, keys[i].Raw...)
+			b = append(b, ':')
+		}
+		b = appendsorted(b, vals[i])
+	}
+	if obj {
+		return append(b, '}')
+	}
+	return append(b, ']')
+}
+
+// styled tells whether the style applies to writing path, which it does
+// for json files.
+func (e *Editor) styled(path string) bool {
+	f, ok := formatof(path)
+	if !ok {
+		f = e.format
+	}
+	return f == formatJSON && e.jsonc == nil
+}
+
+// setstyle changes the style from the write prompt.
+func (e *Editor) setstyle(key termbox.Key) {
+	if e.promptmode != promptWrite || !e.styled(e.writeval) {
+		return
+	}
+	switch key {
+	case 6:
+		e.style = e.style.nextlayout()
+	case 19:
+		e.style.sorted = !e.style.sorted
+	case 14:
+		e.style.newline = !e.style.newline
+	}
+	e.writeredraw()
+}
@@ -0,0 +1,224 @@
+package jd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// schemaref is a schema with the file it's in, which its $refs are
+// relative to.
+type schemaref struct {
+	file string
+	sch  gjson.Result
+}
+
+// expand adds the schema to refs along with the schemas it's made of, so
+// the keywords of a $ref or a branch of allOf, anyOf, oneOf or if are
+// found too.
+func (s *schema) expand(refs []schemaref, file string, sch gjson.Result, depth int) []schemaref {
+	if !isobject(sch) || depth > maxSchemaDepth {
+		return refs
+	}
+	refs = append(refs, schemaref{file, sch})
+	if ref := sch.Get("$ref"); ref.Exists() {
+		if rfile, rsch, err := s.resolve(file, ref.String()); err == nil {
+			refs = s.expand(refs, rfile, rsch, depth+1)
+		}
+	}
+	for _, kw := range []string{"allOf", "anyOf", "oneOf"} {
+		for _, sub := range sch.Get(kw).Array() {
+			refs = s.expand(refs, file, sub, depth+1)
+		}
+	}
+	for _, kw := range []string{"then", "else"} {
+		refs = s.expand(refs, file, sch.Get(kw), depth+1)
+	}
+	return refs
+}
+
+// child returns the schemas of the member part of val, which is a member
+// that's in the document or a key that isn't there yet.
+func (s *schema) child(refs []schemaref, val gjson.Result, part string) []schemaref {
+	var out []schemaref
+	for _, ref := range refs {
+		sch := ref.sch
+		if isarray(val) {
+			i, err := strconv.Atoi(part)
+			if err != nil {
+				continue
+			}
+			prefix := sch.Get("prefixItems").Array()
+			rest := sch.Get("items")
+			if isarray(rest) {
+				prefix, rest = rest.Array(), sch.Get("additionalItems")
+			}
+			if i < len(prefix) {
+				out = s.expand(out, ref.file, prefix[i], 0)
+			} else {
+				out = s.expand(out, ref.file, rest, 0)
+			}
+			continue
+		}
+		matched := false
+		if prop := sch.Get("properties").Get(joinpath("", part)); prop.Exists() {
+			matched = true
+			out = s.expand(out, ref.file, prop, 0)
+		}
+		sch.Get("patternProperties").ForEach(func(pattern, psch gjson.Result) bool {
+			if re := s.regexp(pattern.String()); re != nil && re.MatchString(part) {
+				matched = true
+				out = s.expand(out, ref.file, psch, 0)
+			}
+			return true
+		})
+		if !matched {
+			out = s.expand(out, ref.file, sch.Get("additionalProperties"), 0)
+		}
+	}
+	return out
+}
+
+// properties returns the names of the properties of the schemas, in the
+// order they're listed.
+func properties(refs []schemaref) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		ref.sch.Get("properties").ForEach(func(key, _ gjson.Result) bool {
+			if name := key.String(); !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			return true
+		})
+	}
+	return names
+}
+
+// suggestions returns the defaults of the schemas followed by the values
+// of their const and enum keywords.
+func suggestions(refs []schemaref) []gjson.Result {
+	var vals []gjson.Result
+	add := func(val gjson.Result) {
+		if !val.Exists() {
+			return
+		}
+		for _, v := range vals {
+			if jsonequal(v, val) {
+				return
+			}
+		}
+		vals = append(vals, val)
+	}
+	for _, ref := range refs {
+		add(ref.sch.Get("default"))
+	}
+	for _, ref := range refs {
+		add(ref.sch.Get("const"))
+		for _, val := range ref.sch.Get("enum").Array() {
+			add(val)
+		}
+	}
+	return vals
+}
+
+// schemasat returns the schemas of the value at the path parts, which
+// doesn't need to be in the document.
+func (e *Editor) schemasat(parts []string) []schemaref {
+	if e.schema == nil {
+		return nil
+	}
+	doc, err := e.schema.doc(e.schema.file)
+	if err != nil {
+		return nil
+	}
+	refs := e.schema.expand(nil, e.schema.file, doc, 0)
+	var path string
+	if e.format == formatNDJSON && !e.recview {
+		// each record has the schema of the document
+		if len(parts) == 0 {
+			return nil
+		}
+		path, parts = joinpath("", parts[0]), parts[1:]
+	}
+	for _, part := range parts {
+		val := e.root
+		if path != "" {
+			val = e.get(path)
+		}
+		refs = e.schema.child(refs, val, part)
+		path = joinpath(path, part)
+	}
+	return refs
+}
+
+// newhints returns the properties that the schema allows in the object
+// el and that it doesn't have yet, starting with prefix.
+func (e *Editor) newhints(el gjson.Result, prefix string) []hintkey {
+	if !isobject(el) {
+		return nil
+	}
+	var keys []hintkey
+	for _, name := range properties(e.schemasat(e.parts[:len(e.parts)-1])) {
+		if strings.HasPrefix(name, prefix) && !el.Get(joinpath("", name)).Exists() {
+			key := gjson.Result{Type: gjson.String, Str: name, Raw: string(appendJSONString(nil, name))}
+			keys = append(keys, hintkey{key: key, new: true})
+		}
+	}
+	return keys
+}
+
+// startsuggest finds the values that the schema suggests for the edit bar
+// when editing the selected value.
+func (e *Editor) startsuggest() {
+	var parts []string
+	if e.path != "" {
+		parts = e.parts
+	}
+	e.suggestat(parts)
+}
+
+// suggestat finds the values that the schema suggests for the value at the
+// path parts.
+func (e *Editor) suggestat(parts []string) {
+	e.suggests, e.suggestidx = nil, -1
+	if e.schema == nil {
+		return
+	}
+	for _, val := range suggestions(e.schemasat(parts)) {
+		e.suggests = append(e.suggests, val.Raw)
+	}
+}
+
+// suggest puts the next or previous suggested value in the edit bar.
+func (e *Editor) suggest(next bool) {
+	if len(e.suggests) == 0 {
+		return
+	}
+	if e.suggestidx < 0 && !next {
+		e.suggestidx = len(e.suggests)
+	}
+	if next {
+		e.suggestidx++
+	} else {
+		e.suggestidx--
+	}
+	e.suggestidx = (e.suggestidx%len(e.suggests) + len(e.suggests)) % len(e.suggests)
+	val := gjson.Parse(e.suggests[e.suggestidx])
+	e.edittype = typeAuto
+	if val.Type == gjson.String {
+		e.editval = val.Str
+		if typeAuto.resolve(val.Str) != typeString {
+			// like "123", which would be a number
+			e.edittype = typeString
+		}
+	} else {
+		e.editval = string(compact([]byte(val.Raw)))
+	}
+	e.eidx = len(e.editval)
+	e.seterr(fmt.Errorf("value %d of %d", e.suggestidx+1, len(e.suggests)))
+	e.redraw()
+}
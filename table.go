@@ -0,0 +1,155 @@
+package jd
+
+import (
+	"bytes"
+	"encoding/csv"
+	gojson "encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// errNotTable is returned when a value can't be exported as a table.
+var errNotTable = errors.New("not an array of objects")
+
+// tabular tells whether f is a table that the selected value is exported
+// to, rather than a format for the whole buffer.
+func (f format) tabular() bool {
+	return f == formatCSV || f == formatTSV || f == formatMarkdown
+}
+
+// tablerows flattens the objects of the json array into rows. The columns
+// are the union of the keys in the order they are first seen, with nested
+// objects flattened to dotted paths.
+func tablerows(json []byte) (cols []string, rows []map[string]string, err error) {
+	res := gjson.ParseBytes(json)
+	if res.Type != gjson.JSON || res.Raw[0] != '[' {
+		return nil, nil, errNotTable
+	}
+	seen := make(map[string]bool)
+	res.ForEach(func(_, val gjson.Result) bool {
+		if val.Type != gjson.JSON || val.Raw[0] != '{' {
+			err = errNotTable
+			return false
+		}
+		row := make(map[string]string)
+		flattenrow(val, "", func(col, cell string) {
+			if !seen[col] {
+				seen[col] = true
+				cols = append(cols, col)
+			}
+			row[col] = cell
+		})
+		rows = append(rows, row)
+		return true
+	})
+	return cols, rows, err
+}
+
+// flattenrow calls fn with the dotted path and text of each field of the
+// object. Arrays and empty objects are kept as compact json.
+func flattenrow(obj gjson.Result, prefix string, fn func(col, cell string)) {
+	obj.ForEach(func(key, val gjson.Result) bool {
+		col := prefix + key.String()
+		switch {
+		case val.Type == gjson.JSON && val.Raw[0] == '{' && len(val.Map()) > 0:
+			flattenrow(val, col+".", fn)
+		case val.Type == gjson.JSON:
+			var buf bytes.Buffer
+			if gojson.Compact(&buf, []byte(val.Raw)) != nil {
+				buf.WriteString(val.Raw)
+			}
+			fn(col, buf.String())
+		case val.Type == gjson.Null:
+			fn(col, "")
+		case val.Type == gjson.String:
+			fn(col, val.Str)
+		default:
+			fn(col, val.Raw)
+		}
+		return true
+	})
+}
+
+// jsontotable converts an array of objects to a CSV, TSV or Markdown
+// table.
+func jsontotable(f format, json []byte) ([]byte, error) {
+	cols, rows, err := tablerows(json)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("the objects have no fields")
+	}
+	var buf bytes.Buffer
+	switch f {
+	case formatCSV:
+		w := csv.NewWriter(&buf)
+		w.Write(cols)
+		for _, row := range rows {
+			rec := make([]string, len(cols))
+			for i, col := range cols {
+				rec[i] = row[col]
+			}
+			w.Write(rec)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+	case formatTSV:
+		r := strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+		appendrow := func(cells func(i int) string) {
+			for i := range cols {
+				if i > 0 {
+					buf.WriteByte('\t')
+				}
+				buf.WriteString(r.Replace(cells(i)))
+			}
+			buf.WriteByte('\n')
+		}
+		appendrow(func(i int) string { return cols[i] })
+		for _, row := range rows {
+			appendrow(func(i int) string { return row[cols[i]] })
+		}
+	case formatMarkdown:
+		r := strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r\n", "<br>", "\n", "<br>")
+		appendrow := func(cells func(i int) string) {
+			buf.WriteByte('|')
+			for i := range cols {
+				fmt.Fprintf(&buf, " %s |", r.Replace(cells(i)))
+			}
+			buf.WriteByte('\n')
+		}
+		appendrow(func(i int) string { return cols[i] })
+		appendrow(func(int) string { return "---" })
+		for _, row := range rows {
+			appendrow(func(i int) string { return row[cols[i]] })
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// export writes the selected value to a table file at path.
+func (e *Editor) export(f format, path string) {
+	json, err := Get(e.json, e.apath())
+	if err == nil {
+		json, err = jsontotable(f, json)
+	}
+	if err == nil {
+		json, err = compress(codecof(path), json)
+	}
+	if err == nil {
+		err = savefile(path, json, e.perm, e.backup)
+	}
+	if err != nil {
+		e.writeerr = err
+		e.writeredraw()
+		return
+	}
+	e.writemode = false
+	e.writeerr = errors.New("exported")
+	e.redraw()
+}
@@ -0,0 +1,746 @@
+package jd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// A TOML document is converted to json when it's loaded and back to TOML
+// when it's written. Dates and times become json strings and comments are
+// dropped. Not every json value can be written as TOML, there's no null
+// and the values of an array must all be of the same type.
+
+// tomltable is a table while the document is parsed. Tables can be
+// extended by later headers and dotted keys, so the json is made at the
+// end.
+type tomltable struct {
+	keys    []string
+	vals    map[string]interface{} // *tomltable, *tomltables or raw json
+	defined bool                   // defined by a header or a key
+}
+
+// tomltables is an array of tables.
+type tomltables struct {
+	tables []*tomltable
+}
+
+func newtomltable() *tomltable {
+	return &tomltable{vals: make(map[string]interface{})}
+}
+
+func (t *tomltable) set(key string, val interface{}) {
+	if _, ok := t.vals[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.vals[key] = val
+}
+
+func (t *tomltable) json() []byte {
+	json := []byte{'{'}
+	for i, key := range t.keys {
+		if i > 0 {
+			json = append(json, ',')
+		}
+		json = appendJSONString(json, key)
+		json = append(json, ':')
+		switch val := t.vals[key].(type) {
+		case *tomltable:
+			json = append(json, val.json()...)
+		case *tomltables:
+			json = append(json, '[')
+			for i, t := range val.tables {
+				if i > 0 {
+					json = append(json, ',')
+				}
+				json = append(json, t.json()...)
+			}
+			json = append(json, ']')
+		case []byte:
+			json = append(json, val...)
+		}
+	}
+	return append(json, '}')
+}
+
+type tomlparser struct {
+	s    string
+	i    int
+	lost map[string]bool
+}
+
+// tomltojson converts a TOML document to json. It also returns what's lost
+// when writing it back.
+func tomltojson(b []byte) ([]byte, []string, error) {
+	p := &tomlparser{s: strings.TrimPrefix(string(b), "\ufeff"), lost: make(map[string]bool)}
+	root, err := p.document()
+	if err != nil {
+		return nil, nil, err
+	}
+	var lost []string
+	for _, what := range []string{"comments", "date types"} {
+		if p.lost[what] {
+			lost = append(lost, what)
+		}
+	}
+	return pretty(root.json(), 80), lost, nil
+}
+
+func (p *tomlparser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.s[:p.i], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skip skips spaces and tabs, and also line breaks and comments when
+// lines is true.
+func (p *tomlparser) skip(lines bool) {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t':
+		case '\r', '\n':
+			if !lines {
+				return
+			}
+		case '#':
+			p.lost["comments"] = true
+			for p.i < len(p.s) && p.s[p.i] != '\n' {
+				p.i++
+			}
+			continue
+		default:
+			return
+		}
+		p.i++
+	}
+}
+
+// eol expects the end of the line.
+func (p *tomlparser) eol() error {
+	p.skip(false)
+	if p.i < len(p.s) && p.s[p.i] == '\r' {
+		p.i++
+	}
+	if p.i < len(p.s) && p.s[p.i] != '\n' {
+		return p.errorf("expected the end of the line")
+	}
+	return nil
+}
+
+func (p *tomlparser) document() (*tomltable, error) {
+	root := newtomltable()
+	cur := root
+	for {
+		p.skip(true)
+		if p.i == len(p.s) {
+			return root, nil
+		}
+		var err error
+		if p.s[p.i] == '[' {
+			cur, err = p.header(root)
+		} else {
+			err = p.keyval(cur)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.eol(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// header parses a '[table]' or '[[array.of.tables]]' header and returns
+// the table that the following keys go in.
+func (p *tomlparser) header(root *tomltable) (*tomltable, error) {
+	array := strings.HasPrefix(p.s[p.i:], "[[")
+	if array {
+		p.i += 2
+	} else {
+		p.i++
+	}
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skip(false)
+	end := "]"
+	if array {
+		end = "]]"
+	}
+	if !strings.HasPrefix(p.s[p.i:], end) {
+		return nil, p.errorf("expected '%s'", end)
+	}
+	p.i += len(end)
+	t, err := p.descend(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	val, ok := t.vals[last]
+	if array {
+		if !ok {
+			val = &tomltables{}
+			t.set(last, val)
+		}
+		tables, ok := val.(*tomltables)
+		if !ok {
+			return nil, p.errorf("%q is not an array of tables", last)
+		}
+		nt := newtomltable()
+		nt.defined = true
+		tables.tables = append(tables.tables, nt)
+		return nt, nil
+	}
+	if !ok {
+		nt := newtomltable()
+		t.set(last, nt)
+		val = nt
+	}
+	nt, ok := val.(*tomltable)
+	if !ok || nt.defined {
+		return nil, p.errorf("%q is already defined", last)
+	}
+	nt.defined = true
+	return nt, nil
+}
+
+// descend returns the table at keys below t, creating the tables that
+// don't exist yet. An array of tables continues at its last table.
+func (p *tomlparser) descend(t *tomltable, keys []string) (*tomltable, error) {
+	for _, key := range keys {
+		val, ok := t.vals[key]
+		if !ok {
+			nt := newtomltable()
+			t.set(key, nt)
+			t = nt
+			continue
+		}
+		switch val := val.(type) {
+		case *tomltable:
+			t = val
+		case *tomltables:
+			t = val.tables[len(val.tables)-1]
+		default:
+			return nil, p.errorf("%q is not a table", key)
+		}
+	}
+	return t, nil
+}
+
+// keyval parses a 'key = value' pair into t.
+func (p *tomlparser) keyval(t *tomltable) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skip(false)
+	if p.i == len(p.s) || p.s[p.i] != '=' {
+		return p.errorf("expected '='")
+	}
+	p.i++
+	p.skip(false)
+	val, err := p.value()
+	if err != nil {
+		return err
+	}
+	t, err = p.descend(t, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := t.vals[last]; ok {
+		return p.errorf("duplicate key %q", last)
+	}
+	t.set(last, val)
+	return nil
+}
+
+// key parses a bare, quoted or dotted key.
+func (p *tomlparser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skip(false)
+		if p.i == len(p.s) {
+			return nil, p.errorf("expected a key")
+		}
+		switch p.s[p.i] {
+		case '"', '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, s)
+		default:
+			s := p.i
+			for p.i < len(p.s) && tomlbare(p.s[p.i]) {
+				p.i++
+			}
+			if s == p.i {
+				return nil, p.errorf("expected a key")
+			}
+			keys = append(keys, p.s[s:p.i])
+		}
+		p.skip(false)
+		if p.i == len(p.s) || p.s[p.i] != '.' {
+			return keys, nil
+		}
+		p.i++
+	}
+}
+
+func tomlbare(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-'
+}
+
+func (p *tomlparser) value() ([]byte, error) {
+	if p.i == len(p.s) {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.s[p.i]; {
+	case c == '"' || c == '\'':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONString(nil, s), nil
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlinetable()
+	case strings.HasPrefix(p.s[p.i:], "true"):
+		p.i += 4
+		return []byte("true"), nil
+	case strings.HasPrefix(p.s[p.i:], "false"):
+		p.i += 5
+		return []byte("false"), nil
+	}
+	s := p.i
+	for p.i < len(p.s) && (tomlbare(p.s[p.i]) || strings.IndexByte(":.+", p.s[p.i]) != -1) {
+		p.i++
+	}
+	// a date and a time may be separated by a space
+	if p.i-s == 10 && p.i+3 < len(p.s) && p.s[p.i] == ' ' && p.s[p.i+3] == ':' {
+		p.i++
+		for p.i < len(p.s) && (tomlbare(p.s[p.i]) || strings.IndexByte(":.+", p.s[p.i]) != -1) {
+			p.i++
+		}
+	}
+	tok := p.s[s:p.i]
+	if tomldatetime(tok) {
+		p.lost["date types"] = true
+		return appendJSONString(nil, tok), nil
+	}
+	num, err := tomlnumber(tok)
+	if err != nil {
+		p.i = s
+		return nil, p.errorf("%v", err)
+	}
+	return []byte(num), nil
+}
+
+// tomldatetime tells whether tok is a date, a time or both.
+func tomldatetime(tok string) bool {
+	digits := func(s string) bool {
+		return s != "" && strings.Trim(s, "0123456789") == ""
+	}
+	if len(tok) >= 10 && digits(tok[:4]) && tok[4] == '-' && digits(tok[5:7]) && tok[7] == '-' {
+		return true
+	}
+	return len(tok) >= 8 && digits(tok[:2]) && tok[2] == ':' && digits(tok[3:5]) && tok[5] == ':'
+}
+
+// tomlnumber returns the integer or float tok as a json number.
+func tomlnumber(tok string) (string, error) {
+	if tok == "" {
+		return "", fmt.Errorf("expected a value")
+	}
+	t := strings.TrimPrefix(tok, "+")
+	switch strings.TrimPrefix(t, "-") {
+	case "inf", "nan":
+		return "", fmt.Errorf("%s can't be held in json", tok)
+	}
+	if strings.Contains(t, "__") || strings.HasPrefix(t, "_") || strings.HasSuffix(t, "_") {
+		return "", fmt.Errorf("invalid number %q", tok)
+	}
+	t = strings.Replace(t, "_", "", -1)
+	if len(t) > 2 && t[0] == '0' && strings.IndexByte("xob", t[1]) != -1 {
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[t[1]]
+		n, err := strconv.ParseInt(t[2:], base, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %q", tok)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+	if strings.Trim(t, "0123456789.eE+-") != "" {
+		return "", fmt.Errorf("invalid value %q", tok)
+	}
+	// the decimal numbers of TOML are the ones of json with an optional
+	// plus sign, so there's no '1.', '.5' or '01'
+	if !valid(t) {
+		return "", fmt.Errorf("invalid number %q", tok)
+	}
+	if err := tomlrange(t); err != nil {
+		return "", err
+	}
+	return t, nil
+}
+
+// tomlrange tells whether the json number n fits a TOML integer, which is
+// 64 bits, or a TOML float, which is finite.
+func tomlrange(n string) error {
+	if strings.IndexAny(n, ".eE") == -1 {
+		if _, err := strconv.ParseInt(n, 10, 64); err != nil {
+			return fmt.Errorf("%s is out of the range of a toml integer", n)
+		}
+	} else if _, err := strconv.ParseFloat(n, 64); err != nil {
+		return fmt.Errorf("%s is out of the range of a toml float", n)
+	}
+	return nil
+}
+
+// str parses a basic, literal or multi-line string.
+func (p *tomlparser) str() (string, error) {
+	q := p.s[p.i]
+	multi := strings.HasPrefix(p.s[p.i:], strings.Repeat(string(q), 3))
+	if multi {
+		p.i += 3
+		// a line break right after the quotes is left out
+		if strings.HasPrefix(p.s[p.i:], "\r\n") {
+			p.i += 2
+		} else if strings.HasPrefix(p.s[p.i:], "\n") {
+			p.i++
+		}
+	} else {
+		p.i++
+	}
+	var b []byte
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case c == q && !multi:
+			p.i++
+			return string(b), nil
+		case c == q && strings.HasPrefix(p.s[p.i:], strings.Repeat(string(q), 3)):
+			// up to two quotes may come right before the closing ones
+			n := 3
+			for n < 5 && p.i+n < len(p.s) && p.s[p.i+n] == q {
+				n++
+			}
+			b = append(b, p.s[p.i:p.i+n-3]...)
+			p.i += n
+			return string(b), nil
+		case c == '\n' && !multi:
+			return "", p.errorf("unterminated string")
+		case c == '\\' && q == '"':
+			p.i++
+			if p.i == len(p.s) {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.s[p.i]
+			switch e {
+			case 'b':
+				b = append(b, '\b')
+			case 't':
+				b = append(b, '\t')
+			case 'n':
+				b = append(b, '\n')
+			case 'f':
+				b = append(b, '\f')
+			case 'r':
+				b = append(b, '\r')
+			case 'e':
+				b = append(b, 0x1b)
+			case '"', '\\':
+				b = append(b, e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.i+n >= len(p.s) {
+					return "", p.errorf("invalid escape")
+				}
+				r, err := strconv.ParseUint(p.s[p.i+1:p.i+1+n], 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid escape")
+				}
+				b = append(b, string(rune(r))...)
+				p.i += n
+			default:
+				eol := strings.IndexByte(p.s[p.i:]+"\n", '\n')
+				if !multi || strings.Trim(p.s[p.i:p.i+eol], " \t\r") != "" {
+					return "", p.errorf("invalid escape")
+				}
+				// a backslash at the end of a line trims the whitespace
+				// up to the next text
+				for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) != -1 {
+					p.i++
+				}
+				continue
+			}
+			p.i++
+		default:
+			b = append(b, c)
+			p.i++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *tomlparser) array() ([]byte, error) {
+	p.i++
+	json := []byte{'['}
+	for {
+		p.skip(true)
+		if p.i == len(p.s) {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.s[p.i] == ']' {
+			p.i++
+			return append(json, ']'), nil
+		}
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if len(json) > 1 {
+			json = append(json, ',')
+		}
+		json = append(json, val...)
+		p.skip(true)
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+		} else if p.i < len(p.s) && p.s[p.i] != ']' {
+			return nil, p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *tomlparser) inlinetable() ([]byte, error) {
+	p.i++
+	t := newtomltable()
+	for {
+		p.skip(true)
+		if p.i == len(p.s) {
+			return nil, p.errorf("unterminated inline table")
+		}
+		if p.s[p.i] == '}' {
+			p.i++
+			return t.json(), nil
+		}
+		if err := p.keyval(t); err != nil {
+			return nil, err
+		}
+		p.skip(true)
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+		} else if p.i < len(p.s) && p.s[p.i] != '}' {
+			return nil, p.errorf("expected ',' or '}'")
+		}
+	}
+}
+
+// jsontotoml converts json to a TOML document. It fails for values that
+// TOML can't hold.
+func jsontotoml(json []byte) ([]byte, error) {
+	root := gjson.ParseBytes(json)
+	if !root.Exists() {
+		return nil, nil
+	}
+	if root.Type != gjson.JSON || root.Raw[0] != '{' {
+		return nil, fmt.Errorf("toml needs an object at the top")
+	}
+	return appendtomltable(nil, root, nil, "")
+}
+
+// tomlistables tells whether v is written as an array of tables.
+func tomlistables(v gjson.Result) bool {
+	if v.Type != gjson.JSON || v.Raw[0] != '[' {
+		return false
+	}
+	els := v.Array()
+	for _, el := range els {
+		if el.Type != gjson.JSON || el.Raw[0] != '{' {
+			return false
+		}
+	}
+	return len(els) > 0
+}
+
+func tomlistable(v gjson.Result) bool {
+	return v.Type == gjson.JSON && v.Raw[0] == '{'
+}
+
+// appendtomltable appends the members of the table at keys. The values
+// come first, then the tables and arrays of tables with their headers. The
+// path of the table is for errors.
+func appendtomltable(b []byte, v gjson.Result, keys []string, path string) ([]byte, error) {
+	var err error
+	var values, tables int
+	v.ForEach(func(key, val gjson.Result) bool {
+		if tomlistable(val) || tomlistables(val) {
+			tables++
+			return true
+		}
+		values++
+		b = appendtomlkey(b, key.String())
+		b = append(b, " = "...)
+		b, err = appendtomlvalue(b, val, joinpath(path, key.String()))
+		b = append(b, '\n')
+		return err == nil
+	})
+	if err != nil || tables == 0 {
+		return b, err
+	}
+	v.ForEach(func(key, val gjson.Result) bool {
+		kkeys := append(keys[:len(keys):len(keys)], key.String())
+		kpath := joinpath(path, key.String())
+		if tomlistables(val) {
+			for i, el := range val.Array() {
+				b = appendtomlheader(b, kkeys, true)
+				b, err = appendtomltable(b, el, kkeys, joinpath(kpath, strconv.Itoa(i)))
+				if err != nil {
+					return false
+				}
+			}
+		} else if tomlistable(val) {
+			if !tomlonlytables(val) {
+				b = appendtomlheader(b, kkeys, false)
+			}
+			b, err = appendtomltable(b, val, kkeys, kpath)
+		}
+		return err == nil
+	})
+	return b, err
+}
+
+// tomlonlytables tells whether the table only holds other tables, which
+// makes its header optional.
+func tomlonlytables(v gjson.Result) bool {
+	var n int
+	only := true
+	v.ForEach(func(_, val gjson.Result) bool {
+		n++
+		only = tomlistable(val) || tomlistables(val)
+		return only
+	})
+	return n > 0 && only
+}
+
+func appendtomlheader(b []byte, keys []string, array bool) []byte {
+	if len(b) > 0 {
+		b = append(b, '\n')
+	}
+	b = append(b, '[')
+	if array {
+		b = append(b, '[')
+	}
+	for i, key := range keys {
+		if i > 0 {
+			b = append(b, '.')
+		}
+		b = appendtomlkey(b, key)
+	}
+	b = append(b, ']')
+	if array {
+		b = append(b, ']')
+	}
+	return append(b, '\n')
+}
+
+func appendtomlkey(b []byte, key string) []byte {
+	for i := 0; i < len(key); i++ {
+		if !tomlbare(key[i]) {
+			return appendtomlstring(b, key)
+		}
+	}
+	if key == "" {
+		return append(b, `""`...)
+	}
+	return append(b, key...)
+}
+
+// appendtomlstring appends a basic string. It's like a json string, but
+// DEL must be escaped too.
+func appendtomlstring(b []byte, s string) []byte {
+	return append(b, strings.Replace(string(appendJSONString(nil, s)), "\x7f", `\u007f`, -1)...)
+}
+
+// tomltype returns the TOML type of v for checking that the values of an
+// array are all of the same type.
+func tomltype(v gjson.Result) string {
+	switch v.Type {
+	case gjson.String:
+		return "string"
+	case gjson.True, gjson.False:
+		return "boolean"
+	case gjson.Number:
+		if strings.IndexAny(v.Raw, ".eE") != -1 {
+			return "float"
+		}
+		return "integer"
+	case gjson.JSON:
+		if v.Raw[0] == '{' {
+			return "table"
+		}
+		return "array"
+	}
+	return "null"
+}
+
+// appendtomlvalue appends an inline value.
+func appendtomlvalue(b []byte, v gjson.Result, path string) ([]byte, error) {
+	switch v.Type {
+	case gjson.Null:
+		return nil, fmt.Errorf("toml has no null, at %s", path)
+	case gjson.String:
+		return appendtomlstring(b, v.String()), nil
+	case gjson.Number:
+		if err := tomlrange(v.Raw); err != nil {
+			return nil, fmt.Errorf("%v, at %s", err, path)
+		}
+		return append(b, v.Raw...), nil
+	case gjson.JSON:
+	default:
+		return append(b, v.Raw...), nil
+	}
+	var err error
+	var i int
+	if v.Raw[0] == '{' {
+		b = append(b, '{')
+		v.ForEach(func(key, val gjson.Result) bool {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			i++
+			b = append(b, ' ')
+			b = appendtomlkey(b, key.String())
+			b = append(b, " = "...)
+			b, err = appendtomlvalue(b, val, joinpath(path, key.String()))
+			return err == nil
+		})
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		return append(b, '}'), err
+	}
+	var typ string
+	b = append(b, '[')
+	v.ForEach(func(_, val gjson.Result) bool {
+		if i == 0 {
+			typ = tomltype(val)
+		} else if tomltype(val) != typ {
+			err = fmt.Errorf("toml arrays can't mix %s and %s, at %s", typ, tomltype(val), path)
+			return false
+		} else {
+			b = append(b, ", "...)
+		}
+		b, err = appendtomlvalue(b, val, joinpath(path, strconv.Itoa(i)))
+		i++
+		return err == nil
+	})
+	return append(b, ']'), err
+}
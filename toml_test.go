@@ -0,0 +1,133 @@
+package jd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestTOMLToJSON(t *testing.T) {
+	tests := []struct {
+		toml string
+		json string
+		lost []string
+	}{
+		{"a = 1\nb = \"two\"\n", `{"a":1,"b":"two"}`, nil},
+		{"", `{}`, nil},
+		{"a = 1 # note\n", `{"a":1}`, []string{"comments"}},
+		// keys
+		{"\"a b\" = 1\n'c.d' = 2\n\"\" = 3\n", `{"a b":1,"c.d":2,"":3}`, nil},
+		{"a.b.c = 1\na.d = 2\n", `{"a":{"b":{"c":1},"d":2}}`, nil},
+		{"a . \"b\" = 1\n", `{"a":{"b":1}}`, nil},
+		{"1234 = 1\n", `{"1234":1}`, nil},
+		// strings
+		{`a = "tab\tq\"\u00e9"` + "\n", `{"a":"tab\tq\"é"}`, nil},
+		{`a = 'C:\path'` + "\n", `{"a":"C:\\path"}`, nil},
+		{"a = \"\"\"\none\ntwo\"\"\"\n", `{"a":"one\ntwo"}`, nil},
+		{"a = \"\"\"one \\\n    two\"\"\"\n", `{"a":"one two"}`, nil},
+		{"a = '''\nraw\\n'''\n", `{"a":"raw\\n"}`, nil},
+		// numbers
+		{"a = [+1, -2, 1_000, 0x1F, 0o17, 0b11]\n", `{"a":[1,-2,1000,31,15,3]}`, nil},
+		{"a = [1.5, -0.5e3, 6E-2]\n", `{"a":[1.5,-0.5e3,6E-2]}`, nil},
+		// dates and times
+		{"a = 1979-05-27T07:32:00Z\n", `{"a":"1979-05-27T07:32:00Z"}`, []string{"date types"}},
+		{"a = 1979-05-27 07:32:00.999-07:00\n", `{"a":"1979-05-27 07:32:00.999-07:00"}`, []string{"date types"}},
+		{"a = 1979-05-27\nb = 07:32:00\n", `{"a":"1979-05-27","b":"07:32:00"}`, []string{"date types"}},
+		// arrays and inline tables
+		{"a = [\n  1,\n  2, # two\n]\n", `{"a":[1,2]}`, []string{"comments"}},
+		{"a = [[1, 2], [\"x\"]]\n", `{"a":[[1,2],["x"]]}`, nil},
+		{"a = {x = 1, y.z = \"w\"}\n", `{"a":{"x":1,"y":{"z":"w"}}}`, nil},
+		{"a = {}\nb = []\n", `{"a":{},"b":[]}`, nil},
+		// tables
+		{"[a]\nx = 1\n[a.b]\ny = 2\n[c]\n", `{"a":{"x":1,"b":{"y":2}},"c":{}}`, nil},
+		{"[a.b]\ny = 2\n[a]\nx = 1\n", `{"a":{"b":{"y":2},"x":1}}`, nil},
+		{"[ \"a b\" . c ]\nx = 1\n", `{"a b":{"c":{"x":1}}}`, nil},
+		// arrays of tables
+		{"[[p]]\nx = 1\n[[p]]\nx = 2\n", `{"p":[{"x":1},{"x":2}]}`, nil},
+		{"[[p]]\nx = 1\n[p.q]\ny = 2\n[[p.r]]\nz = 3\n[[p]]\n", `{"p":[{"x":1,"q":{"y":2},"r":[{"z":3}]},{}]}`, nil},
+		// errors
+		{"a = 1\na = 2\n", ``, nil},
+		{"[a]\n[a]\n", ``, nil},
+		{"a = {x = 1}\n[a]\n", ``, nil},
+		{"a = {x = 1}\na.y = 2\n", ``, nil},
+		{"a = 1\n[[a]]\n", ``, nil},
+		{"a = 1 b = 2\n", ``, nil},
+		{"a = \"x\n", ``, nil},
+		{"a =\n", ``, nil},
+		{"a = 1.\n", ``, nil},
+		{"a = .5\n", ``, nil},
+		{"a = 01\n", ``, nil},
+		{"a = 1e\n", ``, nil},
+		{"a = 12345678901234567890\n", ``, nil},
+		{"a = 1e400\n", ``, nil},
+	}
+	for _, tt := range tests {
+		json, lost, err := tomltojson([]byte(tt.toml))
+		if tt.json == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", tt.toml, compact(json))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.toml, err)
+			continue
+		}
+		if got := string(compact(json)); got != tt.json {
+			t.Errorf("%q:\ngot  %s\nwant %s", tt.toml, got, tt.json)
+		}
+		if !reflect.DeepEqual(lost, tt.lost) {
+			t.Errorf("%q: lost %v, want %v", tt.toml, lost, tt.lost)
+		}
+	}
+}
+
+func TestTOMLRoundTrip(t *testing.T) {
+	tests := []string{
+		`{"a":1,"b":"two","c":true,"d":1.5}`,
+		`{"a b":1,"c.d":2,"":3,"1":4,"é":5}`,
+		`{"s":"tab\tq\"\\ \u0001 é\nline"}`,
+		`{"x":1,"t":{"y":2,"u":{"z":3}},"w":{}}`,
+		`{"p":[{"x":1},{"x":2,"q":{"y":3}}],"r":[[1,2],["a"]]}`,
+		`{"m":[{"a":1},{"b":[{"c":2}]}]}`,
+		`{"i":[{"a":1},{"b":2}],"j":{"k":[{}]}}`,
+		`{"e":[],"f":[{"g":[]}]}`,
+	}
+	for _, json := range tests {
+		toml, err := jsontotoml([]byte(json))
+		if err != nil {
+			t.Errorf("%s: %v", json, err)
+			continue
+		}
+		back, lost, err := tomltojson(toml)
+		if err != nil {
+			t.Errorf("%s: %v\n%s", json, err, toml)
+			continue
+		}
+		if len(lost) > 0 {
+			t.Errorf("%s: lost %v", json, lost)
+		}
+		// the values of a table are written before its tables
+		if got := string(compact(back)); !jsonequal(gjson.Parse(got), gjson.Parse(json)) {
+			t.Errorf("%s:\ngot  %s\ntoml\n%s", json, got, strings.TrimSpace(string(toml)))
+		}
+	}
+}
+
+func TestJSONToTOMLErrors(t *testing.T) {
+	tests := []string{
+		`[1,2]`,
+		`"x"`,
+		`{"a":null}`,
+		`{"a":[1,"x"]}`,
+		`{"a":12345678901234567890}`,
+		`{"a":-1e400}`,
+	}
+	for _, json := range tests {
+		if toml, err := jsontotoml([]byte(json)); err == nil {
+			t.Errorf("%s: expected an error, got %q", json, toml)
+		}
+	}
+}
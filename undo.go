@@ -0,0 +1,151 @@
+package jd
+
+import "bytes"
+
+// maxUndoBytes is the memory budget for the undo history. The oldest
+// operations are dropped once the recorded bytes go over it.
+const maxUndoBytes = 8 * 1024 * 1024
+
+// undoop is a single reversible edit of the json buffer. Rather than
+// storing a copy of the document, each op only records the bytes that were
+// replaced and the bytes that replaced them. Undoing a delete then restores
+// the value at its original position, which keeps key order and array
+// indexes intact.
+type undoop struct {
+	path string     // the path that was edited
+	pos  int        // offset of the change in the json buffer
+	prev []byte     // the raw bytes before the edit
+	next []byte     // the raw bytes after the edit
+	segs []jsoncseg // the JSONC segments before the edit
+}
+
+func (op undoop) size() int {
+	return len(op.path) + len(op.prev) + len(op.next)
+}
+
+// makeundoop returns the op that turns a into b.
+func makeundoop(path string, a, b []byte) undoop {
+	// whole blocks are compared first for large buffers
+	var s int
+	for s+4096 <= len(a) && s+4096 <= len(b) && bytes.Equal(a[s:s+4096], b[s:s+4096]) {
+		s += 4096
+	}
+	for s < len(a) && s < len(b) && a[s] == b[s] {
+		s++
+	}
+	ea, eb := len(a), len(b)
+	for ea-4096 > s && eb-4096 > s && bytes.Equal(a[ea-4096:ea], b[eb-4096:eb]) {
+		ea -= 4096
+		eb -= 4096
+	}
+	for ea > s && eb > s && a[ea-1] == b[eb-1] {
+		ea--
+		eb--
+	}
+	return undoop{
+		path: path,
+		pos:  s,
+		prev: append([]byte(nil), a[s:ea]...),
+		next: append([]byte(nil), b[s:eb]...),
+	}
+}
+
+// splice replaces n bytes at pos with b.
+func splice(json []byte, pos, n int, b []byte) []byte {
+	njson := make([]byte, 0, len(json)-n+len(b))
+	njson = append(njson, json[:pos]...)
+	njson = append(njson, b...)
+	return append(njson, json[pos+n:]...)
+}
+
+// commit replaces the json buffer with njson and records the change.
+func (e *Editor) commit(path string, njson []byte) {
+	op := makeundoop(path, e.json, njson)
+	op.segs = e.jsonc.splice(e.json, op.pos, len(op.prev), op.next)
+	e.json = njson
+	e.editdirty = true
+	if len(op.prev) == 0 && len(op.next) == 0 {
+		return
+	}
+	e.pushundo(op)
+	if err := e.journal.edit(op); err != nil {
+		e.seterr(err)
+	}
+}
+
+// pushundo adds an op that was just applied to the undo history, dropping
+// any ops that could have been redone and the oldest ops that no longer
+// fit in the memory budget.
+func (e *Editor) pushundo(op undoop) {
+	if e.savedidx > e.undoidx {
+		// the content on disk can no longer be reached by undo or redo
+		e.savedidx = -1
+	}
+	for i := e.undoidx; i < len(e.undos); i++ {
+		e.undosize -= e.undos[i].size()
+	}
+	e.undos = append(e.undos[:e.undoidx], op)
+	e.undosize += op.size()
+	var n int
+	for e.undosize > maxUndoBytes && n < len(e.undos)-1 {
+		e.undosize -= e.undos[n].size()
+		n++
+	}
+	if n > 0 {
+		e.undos = append(e.undos[:0], e.undos[n:]...)
+		if e.savedidx != -1 {
+			e.savedidx -= n
+			if e.savedidx < 0 {
+				e.savedidx = -1
+			}
+		}
+	}
+	e.undoidx = len(e.undos)
+}
+
+func (e *Editor) undo() {
+	if e.undoidx == 0 {
+		return
+	}
+	e.undoidx--
+	op := e.undos[e.undoidx]
+	e.jsonc.unsplice(e.json, op)
+	e.json = splice(e.json, op.pos, len(op.next), op.prev)
+	if err := e.journal.undo(); err != nil {
+		e.seterr(err)
+	}
+	e.setpath(op.path)
+	e.stopedit()
+	e.editdirty = true
+	e.reflow()
+}
+
+func (e *Editor) redo() {
+	if e.undoidx == len(e.undos) {
+		return
+	}
+	op := e.undos[e.undoidx]
+	e.undoidx++
+	e.jsonc.splice(e.json, op.pos, len(op.prev), op.next)
+	e.json = splice(e.json, op.pos, len(op.prev), op.next)
+	if err := e.journal.redo(); err != nil {
+		e.seterr(err)
+	}
+	e.setpath(op.path)
+	e.stopedit()
+	e.editdirty = true
+	e.reflow()
+}
+
+// modified tells whether the buffer differs from the content on disk.
+func (e *Editor) modified() bool {
+	return e.undoidx != e.savedidx
+}
+
+// setpath selects the value at path in the json buffer.
+func (e *Editor) setpath(path string) {
+	path = e.relpath(path)
+	e.path = path
+	e.pidx = len(path)
+	e.hintline = 0
+}
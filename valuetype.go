@@ -0,0 +1,128 @@
+package jd
+
+import (
+	"errors"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tidwall/gjson"
+)
+
+// valuetype is the type that an edit bar value is stored as.
+type valuetype int
+
+const (
+	typeAuto valuetype = iota // json when valid, otherwise a string
+	typeString
+	typeNumber
+	typeBool
+	typeNull
+	typeJSON
+)
+
+func (t valuetype) String() string {
+	switch t {
+	case typeString:
+		return "string"
+	case typeNumber:
+		return "number"
+	case typeBool:
+		return "boolean"
+	case typeNull:
+		return "null"
+	case typeJSON:
+		return "json"
+	}
+	return "auto"
+}
+
+// next returns the type that follows t when cycling through the types.
+func (t valuetype) next() valuetype {
+	if t == typeJSON {
+		return typeAuto
+	}
+	return t + 1
+}
+
+// resolve returns the type that val gets when stored as t. For typeAuto
+// this is the type of the json value, or typeString when val is not json.
+func (t valuetype) resolve(val string) valuetype {
+	if t != typeAuto {
+		return t
+	}
+	if !valid(val) {
+		return typeString
+	}
+	switch gjson.Parse(val).Type {
+	case gjson.String:
+		return typeString
+	case gjson.Number:
+		return typeNumber
+	case gjson.True, gjson.False:
+		return typeBool
+	case gjson.Null:
+		return typeNull
+	}
+	return typeJSON
+}
+
+// typedvalue returns the json for the edit bar value val stored as t.
+func typedvalue(val string, t valuetype) ([]byte, error) {
+	switch t {
+	case typeAuto:
+		if valid(val) {
+			return []byte(val), nil
+		}
+		return appendJSONString(nil, val), nil
+	case typeString:
+		return appendJSONString(nil, val), nil
+	case typeNumber:
+		if !valid(val) || gjson.Parse(val).Type != gjson.Number {
+			return nil, errors.New("invalid number")
+		}
+	case typeBool:
+		if val != "true" && val != "false" {
+			return nil, errors.New("invalid boolean")
+		}
+	case typeNull:
+		return []byte("null"), nil
+	case typeJSON:
+		if err := validerr(val); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(val), nil
+}
+
+// cycletype switches the edit bar to the next value type. A json string
+// loses its quotes when switching to string, so that the text stays the
+// same value.
+func (e *Editor) cycletype() {
+	prev := e.edittype.resolve(e.editval)
+	e.edittype = e.edittype.next()
+	if e.edittype == typeString && prev == typeString && valid(e.editval) {
+		e.editval = gjson.Parse(e.editval).String()
+		e.eidx = len(e.editval)
+	}
+	e.redraw()
+}
+
+// blittype draws the type of the edit bar value at the end of the bar.
+func (e *Editor) blittype() {
+	label := e.edittype.resolve(e.editval).String()
+	if e.edittype == typeAuto {
+		label += " (auto)"
+	}
+	fg, bg := e.fg, e.bg
+	if _, err := typedvalue(e.editval, e.edittype); err != nil {
+		label = "invalid " + e.edittype.String()
+		fg, bg = termbox.ColorWhite|termbox.AttrBold, termbox.ColorRed
+	}
+	label = " " + label + " "
+	x := e.w - len(label)
+	if x < 0 {
+		x = 0
+	}
+	for i, c := range label {
+		termbox.SetCell(x+i, e.statusy+1, c, fg, bg)
+	}
+}
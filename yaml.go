@@ -0,0 +1,1026 @@
+package jd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// A YAML document is converted to json when it's loaded and back to YAML
+// when it's written, keeping the order of the keys. Comments and tags have
+// no place in json and are dropped, anchors are expanded where they are
+// used. Only a single document is supported.
+
+type yamlline struct {
+	indent int
+	text   string // without the indentation and the comment
+	raw    string
+	parsed bool
+}
+
+type yamlparser struct {
+	lines   []yamlline
+	i       int
+	anchors map[string][]byte
+	lost    map[string]bool
+}
+
+// yamltojson converts a YAML document to json. It also returns the YAML
+// features that were used and are lost when writing it back.
+func yamltojson(b []byte) ([]byte, []string, error) {
+	p := &yamlparser{
+		anchors: make(map[string][]byte),
+		lost:    make(map[string]bool),
+	}
+	for _, raw := range strings.Split(strings.TrimPrefix(string(b), "\ufeff"), "\n") {
+		raw = strings.TrimSuffix(raw, "\r")
+		n := len(raw) - len(strings.TrimLeft(raw, " "))
+		p.lines = append(p.lines, yamlline{indent: n, raw: raw})
+	}
+	json, err := p.document()
+	if err != nil {
+		return nil, nil, err
+	}
+	var lost []string
+	for _, what := range []string{"comments", "tags", "anchors", "folded blocks", "infinite and NaN floats"} {
+		if p.lost[what] {
+			lost = append(lost, what)
+		}
+	}
+	if len(json) > 0 {
+		json = pretty(json, 80)
+	}
+	return json, lost, nil
+}
+
+func (p *yamlparser) errorf(format string, args ...interface{}) error {
+	n := p.i + 1
+	if n > len(p.lines) {
+		n = len(p.lines)
+	}
+	return fmt.Errorf("line %d: %s", n, fmt.Sprintf(format, args...))
+}
+
+// line returns the line at i without its comment.
+func (p *yamlparser) line(i int) *yamlline {
+	l := &p.lines[i]
+	if !l.parsed {
+		l.parsed = true
+		text := l.raw[l.indent:]
+		if j := yamlcomment(text); j != -1 {
+			text = text[:j]
+			p.lost["comments"] = true
+		}
+		l.text = strings.TrimSpace(text)
+	}
+	return l
+}
+
+// yamlcomment returns the index of the comment in s, or -1.
+func yamlcomment(s string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				if quote == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+					i++
+				} else {
+					quote = 0
+				}
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return i
+		case (c == '"' || c == '\'') && yamlquotestart(s, i):
+			quote = c
+		}
+	}
+	return -1
+}
+
+// yamlquotestart tells whether the quote at i starts a quoted scalar rather
+// than being part of a plain one.
+func yamlquotestart(s string, i int) bool {
+	j := i - 1
+	for j >= 0 && (s[j] == ' ' || s[j] == '\t') {
+		j--
+	}
+	if j < 0 || strings.IndexByte("[{,?", s[j]) != -1 {
+		return true
+	}
+	return (s[j] == ':' || s[j] == '-') && j < i-1
+}
+
+// peek skips blank lines and tells whether there's another line in the
+// document.
+func (p *yamlparser) peek() bool {
+	for ; p.i < len(p.lines); p.i++ {
+		l := p.line(p.i)
+		if l.text == "" {
+			continue
+		}
+		return l.indent > 0 || !yamlmarker(l.text, "---") && !yamlmarker(l.text, "...")
+	}
+	return false
+}
+
+func yamlmarker(text, marker string) bool {
+	return text == marker || strings.HasPrefix(text, marker+" ")
+}
+
+func yamlisseq(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ") || strings.HasPrefix(text, "-\t")
+}
+
+func yamliskey(text string) bool {
+	_, _, ok := yamlsplitkey(text)
+	return ok
+}
+
+// yamlsplitkey splits a 'key: value' line.
+func yamlsplitkey(text string) (key, rest string, ok bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' || yamlisseq(text) {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		key, n, ok := yamlquoted(text)
+		if !ok {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[n:], " \t")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") && !strings.HasPrefix(rest, ":\t") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\t') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func (p *yamlparser) document() ([]byte, error) {
+	var json []byte
+	var err error
+	for ; p.i < len(p.lines); p.i++ {
+		l := p.line(p.i)
+		if l.text == "" || l.indent == 0 && strings.HasPrefix(l.text, "%") {
+			// blank lines and directives
+			continue
+		}
+		if l.indent == 0 && yamlmarker(l.text, "---") {
+			p.i++
+			json, err = p.value(strings.TrimSpace(l.text[3:]), -1, true)
+		} else if p.peek() {
+			json, err = p.node()
+		}
+		break
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() {
+		return nil, p.errorf("bad indentation")
+	}
+	for ; p.i < len(p.lines); p.i++ {
+		l := p.line(p.i)
+		if l.text != "" && l.text != "---" && l.text != "..." {
+			return nil, p.errorf("multiple documents are not supported")
+		}
+	}
+	return json, nil
+}
+
+// node parses the node that starts on the current line.
+func (p *yamlparser) node() ([]byte, error) {
+	l := p.line(p.i)
+	switch {
+	case yamlisseq(l.text):
+		return p.seq(l.indent)
+	case strings.HasPrefix(l.text, "? "):
+		return nil, p.errorf("complex keys are not supported")
+	case yamliskey(l.text):
+		return p.mapping(l.indent)
+	}
+	p.i++
+	return p.value(l.text, l.indent-1, false)
+}
+
+// value parses the value that follows a key or a '-' at indent ind. It may
+// continue on the lines that are indented more.
+func (p *yamlparser) value(rest string, ind int, inmap bool) ([]byte, error) {
+	anchor, tag, rest := p.props(rest)
+	var val []byte
+	var err error
+	switch {
+	case rest == "":
+		val = []byte("null")
+		if p.peek() {
+			l := p.line(p.i)
+			// a sequence may be at the same indent as its key
+			if l.indent > ind || inmap && l.indent == ind && yamlisseq(l.text) {
+				val, err = p.node()
+			}
+		}
+	case rest[0] == '|' || rest[0] == '>':
+		val, err = p.block(rest, ind)
+	case rest[0] == '*':
+		val, err = p.alias(rest)
+	default:
+		val, err = p.inline(rest, ind, tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" {
+		p.anchors[anchor] = val
+	}
+	return val, nil
+}
+
+// props strips the anchor and the tag in front of a value.
+func (p *yamlparser) props(s string) (anchor, tag, rest string) {
+	for len(s) > 0 && (s[0] == '&' || s[0] == '!') {
+		i := strings.IndexAny(s, " \t")
+		if i == -1 {
+			i = len(s)
+		}
+		if s[0] == '&' {
+			anchor = s[1:i]
+			p.lost["anchors"] = true
+		} else {
+			tag = s[:i]
+			switch tag {
+			case "!!str", "!!int", "!!float", "!!bool", "!!null", "!!map", "!!seq":
+			default:
+				p.lost["tags"] = true
+			}
+		}
+		s = strings.TrimLeft(s[i:], " \t")
+	}
+	return anchor, tag, s
+}
+
+func (p *yamlparser) alias(s string) ([]byte, error) {
+	p.lost["anchors"] = true
+	val, ok := p.anchors[s[1:]]
+	if !ok {
+		return nil, p.errorf("unknown anchor %q", s[1:])
+	}
+	return val, nil
+}
+
+func (p *yamlparser) mapping(ind int) ([]byte, error) {
+	type member struct {
+		key   string
+		val   []byte
+		merge bool
+	}
+	var members []member
+	explicit := make(map[string]bool)
+	for p.peek() {
+		l := p.line(p.i)
+		if l.indent != ind {
+			break
+		}
+		key, rest, ok := yamlsplitkey(l.text)
+		if !ok {
+			return nil, p.errorf("expected a key")
+		}
+		if key == "<<" {
+			p.i++
+			val, err := p.value(rest, ind, true)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, member{val: val, merge: true})
+			continue
+		}
+		if explicit[key] {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		explicit[key] = true
+		p.i++
+		val, err := p.value(rest, ind, true)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member{key: key, val: val})
+	}
+	if p.peek() && p.line(p.i).indent > ind {
+		return nil, p.errorf("bad indentation")
+	}
+	json := []byte{'{'}
+	seen := make(map[string]bool)
+	add := func(key string, val []byte) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		if len(json) > 1 {
+			json = append(json, ',')
+		}
+		json = appendJSONString(json, key)
+		json = append(json, ':')
+		json = append(json, val...)
+	}
+	for _, m := range members {
+		if !m.merge {
+			add(m.key, m.val)
+			continue
+		}
+		// merge keys, the keys of the mapping itself win
+		var maps []gjson.Result
+		res := gjson.ParseBytes(m.val)
+		if res.Type == gjson.JSON && res.Raw[0] == '[' {
+			maps = res.Array()
+		} else {
+			maps = []gjson.Result{res}
+		}
+		for _, res := range maps {
+			if res.Type != gjson.JSON || res.Raw[0] != '{' {
+				return nil, fmt.Errorf("merge key needs a mapping")
+			}
+			res.ForEach(func(key, val gjson.Result) bool {
+				if !explicit[key.String()] {
+					add(key.String(), []byte(val.Raw))
+				}
+				return true
+			})
+		}
+	}
+	return append(json, '}'), nil
+}
+
+func (p *yamlparser) seq(ind int) ([]byte, error) {
+	json := []byte{'['}
+	for p.peek() {
+		l := p.line(p.i)
+		if l.indent != ind || !yamlisseq(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(l.text[1:], " \t")
+		var val []byte
+		var err error
+		if rest != "" && (yamlisseq(rest) || yamliskey(rest)) {
+			// a compact collection like '- key: value', which continues
+			// at the indent of its first key.
+			l.indent += len(l.text) - len(rest)
+			l.text = rest
+			val, err = p.node()
+		} else {
+			p.i++
+			val, err = p.value(rest, ind, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(json) > 1 {
+			json = append(json, ',')
+		}
+		json = append(json, val...)
+	}
+	if p.peek() && p.line(p.i).indent > ind {
+		return nil, p.errorf("bad indentation")
+	}
+	return append(json, ']'), nil
+}
+
+// block parses a literal '|' or folded '>' block scalar, which is on the
+// lines that are indented more than ind.
+func (p *yamlparser) block(header string, ind int) ([]byte, error) {
+	folded := header[0] == '>'
+	var chomp byte
+	indent := -1
+	for i := 1; i < len(header); i++ {
+		switch c := header[i]; {
+		case c == '-' || c == '+':
+			chomp = c
+		case c >= '1' && c <= '9':
+			indent = int(c - '0')
+			if ind > 0 {
+				indent += ind
+			}
+		default:
+			return nil, p.errorf("invalid block scalar header %q", header)
+		}
+	}
+	var lines []string
+	for ; p.i < len(p.lines); p.i++ {
+		l := &p.lines[p.i]
+		if strings.TrimSpace(l.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if indent == -1 {
+			if l.indent <= ind {
+				break
+			}
+			indent = l.indent
+		}
+		if l.indent < indent {
+			break
+		}
+		lines = append(lines, l.raw[indent:])
+	}
+	n := len(lines)
+	for n > 0 && lines[n-1] == "" {
+		n--
+	}
+	trailing := len(lines) - n
+	lines = lines[:n]
+	var s string
+	if folded {
+		// the string is written back as a literal block
+		p.lost["folded blocks"] = true
+		s = yamlfold(lines)
+	} else {
+		s = strings.Join(lines, "\n")
+	}
+	switch chomp {
+	case '-':
+	case '+':
+		if n > 0 {
+			s += "\n"
+		}
+		s += strings.Repeat("\n", trailing)
+	default:
+		if n > 0 {
+			s += "\n"
+		}
+	}
+	return appendJSONString(nil, s), nil
+}
+
+// yamlfold joins the lines of a folded block scalar. Lines are joined with
+// a space, blank lines become line breaks and lines that are indented more
+// keep their line breaks.
+func yamlfold(lines []string) string {
+	more := func(line string) bool {
+		return line != "" && (line[0] == ' ' || line[0] == '\t')
+	}
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			switch {
+			case prev == "":
+				b.WriteByte('\n')
+				if more(line) {
+					// the break after the last text line is kept too
+					j := i - 1
+					for j > 0 && lines[j] == "" {
+						j--
+					}
+					if lines[j] != "" && !more(lines[j]) {
+						b.WriteByte('\n')
+					}
+				}
+			case line == "":
+				if more(prev) {
+					b.WriteByte('\n')
+				}
+			case more(prev) || more(line):
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// inline parses a scalar or a flow collection that starts on the line that
+// was just read.
+func (p *yamlparser) inline(text string, ind int, tag string) ([]byte, error) {
+	switch text[0] {
+	case '[', '{':
+		for !yamlbalanced(text) && p.i < len(p.lines) {
+			text += " " + p.line(p.i).text
+			p.i++
+		}
+		f := &yamlflow{p: p, s: text}
+		val, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.skip()
+		if f.i != len(f.s) {
+			return nil, p.errorf("unexpected %q after flow collection", f.s[f.i:])
+		}
+		return val, nil
+	case '"', '\'':
+		// quoted scalars may span lines, which are folded
+		for p.i < len(p.lines) {
+			if _, _, ok := yamlquoted(text); ok {
+				break
+			}
+			line := strings.TrimSpace(p.lines[p.i].raw)
+			p.i++
+			if line == "" {
+				text += "\n"
+			} else if strings.HasSuffix(text, "\n") {
+				text += line
+			} else {
+				text += " " + line
+			}
+		}
+		s, n, ok := yamlquoted(text)
+		if !ok {
+			return nil, p.errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(text[n:]); rest != "" {
+			if rest[0] != '#' {
+				return nil, p.errorf("unexpected %q after string", rest)
+			}
+			p.lost["comments"] = true
+		}
+		return appendJSONString(nil, s), nil
+	}
+	if yamliskey(text) {
+		// like 'a: b: c', the error is on the line that was read
+		p.i--
+		return nil, p.errorf("mapping values are not allowed here")
+	}
+	// plain scalars continue on the lines that are indented more
+	for p.peek() && p.line(p.i).indent > ind {
+		if yamliskey(p.line(p.i).text) {
+			return nil, p.errorf("bad indentation")
+		}
+		text += " " + p.line(p.i).text
+		p.i++
+	}
+	return p.scalar(text, tag), nil
+}
+
+// yamlbalanced tells whether every bracket of a flow collection is closed.
+func yamlbalanced(s string) bool {
+	var depth int
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// yamlquoted parses the single or double quoted scalar at the start of s
+// and returns its value and length.
+func yamlquoted(s string) (string, int, bool) {
+	q := s[0]
+	var b []byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case q == '\'' && c == '\'':
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b = append(b, '\'')
+				i++
+				continue
+			}
+			return string(b), i + 1, true
+		case q == '"' && c == '"':
+			return string(b), i + 1, true
+		case q == '"' && c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '0':
+				b = append(b, 0)
+			case 'a':
+				b = append(b, '\a')
+			case 'b':
+				b = append(b, '\b')
+			case 't', '\t':
+				b = append(b, '\t')
+			case 'n':
+				b = append(b, '\n')
+			case 'v':
+				b = append(b, '\v')
+			case 'f':
+				b = append(b, '\f')
+			case 'r':
+				b = append(b, '\r')
+			case 'e':
+				b = append(b, 0x1b)
+			case ' ', '"', '/', '\\':
+				b = append(b, s[i])
+			case 'N':
+				b = append(b, "\u0085"...)
+			case '_':
+				b = append(b, "\u00a0"...)
+			case 'L':
+				b = append(b, "\u2028"...)
+			case 'P':
+				b = append(b, "\u2029"...)
+			case 'x', 'u', 'U':
+				n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+				if i+n >= len(s) {
+					return "", 0, false
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+				if err != nil {
+					return "", 0, false
+				}
+				b = append(b, string(rune(r))...)
+				i += n
+			default:
+				return "", 0, false
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", 0, false
+}
+
+// scalar converts a plain scalar like yamlscalar and notes the floats that
+// json can't hold, which are read as strings.
+func (p *yamlparser) scalar(s, tag string) []byte {
+	if tag != "!!str" && yamlnonfinite(s) {
+		p.lost["infinite and NaN floats"] = true
+	}
+	return yamlscalar(s, tag)
+}
+
+// yamlnonfinite tells whether s is a YAML .inf or .nan float.
+func yamlnonfinite(s string) bool {
+	switch s {
+	case ".nan", ".NaN", ".NAN":
+		return true
+	}
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	return s == ".inf" || s == ".Inf" || s == ".INF"
+}
+
+// yamlscalar resolves a plain scalar to a json value.
+func yamlscalar(s, tag string) []byte {
+	if tag == "!!str" {
+		return appendJSONString(nil, s)
+	}
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return []byte("null")
+	case "true", "True", "TRUE":
+		return []byte("true")
+	case "false", "False", "FALSE":
+		return []byte("false")
+	}
+	if num, ok := yamlnumber(s); ok {
+		return []byte(num)
+	}
+	return appendJSONString(nil, s)
+}
+
+// yamlnumber returns s as a json number when it's a YAML int or float.
+func yamlnumber(s string) (string, bool) {
+	t := strings.TrimPrefix(s, "+")
+	if strings.HasPrefix(t, "0x") || strings.HasPrefix(t, "0o") {
+		base := 16
+		if t[1] == 'o' {
+			base = 8
+		}
+		n, err := strconv.ParseInt(t[2:], base, 64)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatInt(n, 10), true
+	}
+	if t == "" || strings.Trim(t, "0123456789.eE+-") != "" || strings.IndexAny(t, "0123456789") == -1 {
+		return "", false
+	}
+	f, err := strconv.ParseFloat(t, 64)
+	if err != nil {
+		return "", false
+	}
+	if valid(t) {
+		return t, true
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), true
+}
+
+// yamlflow parses a flow collection like '[a, b]' or '{a: 1}'.
+type yamlflow struct {
+	p *yamlparser
+	s string
+	i int
+}
+
+func (f *yamlflow) skip() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+		f.i++
+	}
+}
+
+func (f *yamlflow) value() ([]byte, error) {
+	f.skip()
+	if f.i == len(f.s) {
+		return nil, f.p.errorf("unexpected end of flow collection")
+	}
+	switch f.s[f.i] {
+	case '[':
+		return f.seq()
+	case '{':
+		return f.mapping()
+	case '"', '\'':
+		s, n, ok := yamlquoted(f.s[f.i:])
+		if !ok {
+			return nil, f.p.errorf("unterminated string")
+		}
+		f.i += n
+		return appendJSONString(nil, s), nil
+	}
+	start := f.i
+	for f.i < len(f.s) && strings.IndexByte(",]}", f.s[f.i]) == -1 {
+		if f.s[f.i] == ':' && (f.i+1 == len(f.s) || strings.IndexByte(" \t,]}", f.s[f.i+1]) != -1) {
+			break
+		}
+		f.i++
+	}
+	anchor, tag, text := f.p.props(strings.TrimSpace(f.s[start:f.i]))
+	var val []byte
+	if strings.HasPrefix(text, "*") {
+		var err error
+		if val, err = f.p.alias(text); err != nil {
+			return nil, err
+		}
+	} else {
+		val = f.p.scalar(text, tag)
+	}
+	if anchor != "" {
+		f.p.anchors[anchor] = val
+	}
+	return val, nil
+}
+
+// next skips the ',' after an entry, which is left out before the close
+// byte.
+func (f *yamlflow) next(close byte) error {
+	f.skip()
+	if f.i < len(f.s) && f.s[f.i] == ',' {
+		f.i++
+		return nil
+	}
+	if f.i < len(f.s) && f.s[f.i] == close {
+		return nil
+	}
+	return f.p.errorf("expected ',' or '%c' in flow collection", close)
+}
+
+func (f *yamlflow) seq() ([]byte, error) {
+	f.i++
+	json := []byte{'['}
+	for {
+		f.skip()
+		if f.i < len(f.s) && f.s[f.i] == ']' {
+			f.i++
+			return append(json, ']'), nil
+		}
+		val, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if len(json) > 1 {
+			json = append(json, ',')
+		}
+		json = append(json, val...)
+		if err := f.next(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlflow) mapping() ([]byte, error) {
+	f.i++
+	json := []byte{'{'}
+	seen := make(map[string]bool)
+	for {
+		f.skip()
+		if f.i < len(f.s) && f.s[f.i] == '}' {
+			f.i++
+			return append(json, '}'), nil
+		}
+		raw, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		res := gjson.ParseBytes(raw)
+		if res.Type == gjson.JSON {
+			return nil, f.p.errorf("complex keys are not supported")
+		}
+		key := res.Raw
+		if res.Type == gjson.String {
+			key = res.String()
+		}
+		if seen[key] {
+			return nil, f.p.errorf("duplicate key %q", key)
+		}
+		seen[key] = true
+		val := []byte("null")
+		f.skip()
+		if f.i < len(f.s) && f.s[f.i] == ':' {
+			f.i++
+			if val, err = f.value(); err != nil {
+				return nil, err
+			}
+		}
+		if len(json) > 1 {
+			json = append(json, ',')
+		}
+		json = appendJSONString(json, key)
+		json = append(json, ':')
+		json = append(json, val...)
+		if err := f.next('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// jsontoyaml converts json to a YAML document.
+func jsontoyaml(json []byte) []byte {
+	root := gjson.ParseBytes(json)
+	if !root.Exists() {
+		return nil
+	}
+	if yamlblock(root) {
+		return appendyamlblock(nil, root, 0, false)
+	}
+	return append(appendyamlscalar(nil, root, 2), '\n')
+}
+
+// yamlblock tells whether v is written as a block collection, which is
+// any object or array that is not empty.
+func yamlblock(v gjson.Result) bool {
+	var n int
+	if v.Type == gjson.JSON {
+		v.ForEach(func(_, _ gjson.Result) bool {
+			n++
+			return false
+		})
+	}
+	return n > 0
+}
+
+// appendyamlblock appends the members of an object or array at indent ind.
+// When inline is true the first member goes on the current line, like the
+// first key of a mapping in a sequence.
+func appendyamlblock(b []byte, v gjson.Result, ind int, inline bool) []byte {
+	first := true
+	v.ForEach(func(key, val gjson.Result) bool {
+		if !first || !inline {
+			b = append(b, strings.Repeat(" ", ind)...)
+		}
+		first = false
+		if v.Raw[0] == '{' {
+			b = appendyamlkey(b, key.String())
+			b = append(b, ':')
+			if yamlblock(val) {
+				b = append(b, '\n')
+				b = appendyamlblock(b, val, ind+2, false)
+				return true
+			}
+		} else {
+			b = append(b, '-')
+			if yamlblock(val) {
+				b = append(b, ' ')
+				b = appendyamlblock(b, val, ind+2, true)
+				return true
+			}
+		}
+		b = append(b, ' ')
+		b = appendyamlscalar(b, val, ind+2)
+		b = append(b, '\n')
+		return true
+	})
+	return b
+}
+
+func appendyamlkey(b []byte, key string) []byte {
+	if key != "<<" && yamlplain(key) {
+		return append(b, key...)
+	}
+	return appendJSONString(b, key)
+}
+
+// appendyamlscalar appends a value that is not a block collection. The
+// lines of a literal block scalar are indented by ind.
+func appendyamlscalar(b []byte, v gjson.Result, ind int) []byte {
+	switch v.Type {
+	case gjson.String:
+		s := v.String()
+		if yamlliteral(s) {
+			return appendyamlliteral(b, s, ind)
+		}
+		if yamlplain(s) {
+			return append(b, s...)
+		}
+		return appendJSONString(b, s)
+	case gjson.JSON:
+		if v.Raw[0] == '{' {
+			return append(b, "{}"...)
+		}
+		return append(b, "[]"...)
+	}
+	return append(b, v.Raw...)
+}
+
+// yamlplain tells whether s can be written without quotes and is read back
+// as the same string, also by YAML 1.1 parsers.
+func yamlplain(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return false
+	}
+	if string(yamlscalar(s, "")) != string(appendJSONString(nil, s)) {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "y", "n", "yes", "no", "on", "off", ".inf", "-.inf", "+.inf", ".nan":
+		return false
+	}
+	if strings.IndexByte("-?:,[]{}#&*!|>'\"%@`", s[0]) != -1 || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return false
+	}
+	for _, r := range s {
+		if r < ' ' || r == 0x7f || r == 0x85 || r == 0x2028 || r == 0x2029 || r == 0xfeff {
+			return false
+		}
+	}
+	return true
+}
+
+// yamlliteral tells whether s is written as a literal block scalar, which
+// is for strings with line breaks.
+func yamlliteral(s string) bool {
+	body := strings.TrimRight(s, "\n")
+	if body == "" || !strings.Contains(s, "\n") {
+		return false
+	}
+	firstline := true
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" && strings.TrimSpace(line) == "" {
+			return false
+		}
+		if firstline && line != "" {
+			if line[0] == ' ' || line[0] == '\t' {
+				// would need an indentation indicator
+				return false
+			}
+			firstline = false
+		}
+	}
+	for _, r := range s {
+		if r < ' ' && r != '\n' && r != '\t' || r == 0x7f || r == 0x85 || r == 0x2028 || r == 0x2029 || r == 0xfeff {
+			return false
+		}
+	}
+	return true
+}
+
+func appendyamlliteral(b []byte, s string, ind int) []byte {
+	body := strings.TrimRight(s, "\n")
+	trailing := len(s) - len(body)
+	switch trailing {
+	case 0:
+		b = append(b, "|-"...)
+	case 1:
+		b = append(b, '|')
+	default:
+		b = append(b, "|+"...)
+	}
+	for _, line := range strings.Split(body, "\n") {
+		b = append(b, '\n')
+		if line != "" {
+			b = append(b, strings.Repeat(" ", ind)...)
+			b = append(b, line...)
+		}
+	}
+	for i := 1; i < trailing; i++ {
+		b = append(b, '\n')
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package jd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	tests := []struct {
+		yaml string
+		json string
+		lost []string
+	}{
+		// block collections
+		{"a: 1\nb: two\n", `{"a":1,"b":"two"}`, nil},
+		{"- 1\n- 2\n", `[1,2]`, nil},
+		{"a:\n  - x\n  - y\nb:\n  c: true\n", `{"a":["x","y"],"b":{"c":true}}`, nil},
+		{"a:\n- x\n- y\n", `{"a":["x","y"]}`, nil},
+		{"- a: 1\n  b: 2\n- c: 3\n", `[{"a":1,"b":2},{"c":3}]`, nil},
+		{"- - 1\n  - 2\n- []\n", `[[1,2],[]]`, nil},
+		{"a:\nb: ~\nc: null\n", `{"a":null,"b":null,"c":null}`, nil},
+		// flow collections
+		{"a: [1, two, \"3\"]\n", `{"a":[1,"two","3"]}`, nil},
+		{"{a: 1, b: [x, {c: d}]}\n", `{"a":1,"b":["x",{"c":"d"}]}`, nil},
+		{"a: [\n  1,\n  2\n]\n", `{"a":[1,2]}`, nil},
+		{"a: {}\n", `{"a":{}}`, nil},
+		// scalars
+		{"- 0x1F\n- 1.5\n- -3\n- .inf\n- yes\n- false\n", `[31,1.5,-3,".inf","yes",false]`, []string{"infinite and NaN floats"}},
+		{"a: [-.Inf, .nan]\nb: !!str .inf\nc: '.inf'\n", `{"a":["-.Inf",".nan"],"b":".inf","c":".inf"}`, []string{"infinite and NaN floats"}},
+		{"- .info\n- -.nan\n", `[".info","-.nan"]`, nil},
+		{"- 'it''s'\n- \"tab\\tnew\\n\"\n- \"\\u00e9\"\n", `["it's","tab\tnew\n","é"]`, nil},
+		{"a: b: c\n", ``, nil},
+		{"a: x # note\n", `{"a":"x"}`, []string{"comments"}},
+		{"a: 'x # y'\n", `{"a":"x # y"}`, nil},
+		{"url: http://x.y/z#a\n", `{"url":"http://x.y/z#a"}`, nil},
+		// block scalars
+		{"a: |\n  one\n  two\nb: 1\n", `{"a":"one\ntwo\n","b":1}`, nil},
+		{"a: >\n  one\n  two\n\n  three\n", `{"a":"one two\nthree\n"}`, []string{"folded blocks"}},
+		{"a: |-\n  one\n", `{"a":"one"}`, nil},
+		{"a: |+\n  one\n\nb: 1\n", `{"a":"one\n\n","b":1}`, nil},
+		{"a: |2\n    indented\n", `{"a":"  indented\n"}`, nil},
+		// anchors, aliases and merge keys
+		{"a: &x 1\nb: *x\n", `{"a":1,"b":1}`, []string{"anchors"}},
+		{"base: &b\n  x: 1\n  y: 2\nc:\n  <<: *b\n  y: 3\n", `{"base":{"x":1,"y":2},"c":{"x":1,"y":3}}`, []string{"anchors"}},
+		{"a: &a {x: 1}\nb: &b {y: 2}\nc:\n  <<: [*a, *b]\n", `{"a":{"x":1},"b":{"y":2},"c":{"x":1,"y":2}}`, []string{"anchors"}},
+		{"a: *nope\n", ``, nil},
+		// quoted keys
+		{"\"a b\": 1\n'c: d': 2\n\"\": 3\n", `{"a b":1,"c: d":2,"":3}`, nil},
+		{"\"1\": x\n", `{"1":"x"}`, nil},
+		// tags and documents
+		{"a: !!str 1\n", `{"a":"1"}`, nil},
+		{"a: !point {x: 1}\n", `{"a":{"x":1}}`, []string{"tags"}},
+		{"---\na: 1\n...\n", `{"a":1}`, nil},
+		{"", ``, nil},
+		// errors
+		{"a: 1\n b: 2\n", ``, nil},
+		{"- 1\na: 2\n", ``, nil},
+	}
+	for _, tt := range tests {
+		json, lost, err := yamltojson([]byte(tt.yaml))
+		if tt.json == "" && tt.yaml != "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", tt.yaml, compact(json))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.yaml, err)
+			continue
+		}
+		if got := string(compact(json)); got != tt.json {
+			t.Errorf("%q:\ngot  %s\nwant %s", tt.yaml, got, tt.json)
+		}
+		if !reflect.DeepEqual(lost, tt.lost) {
+			t.Errorf("%q: lost %v, want %v", tt.yaml, lost, tt.lost)
+		}
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	tests := []string{
+		`{"a":1,"b":"two","c":[true,false,null]}`,
+		`[{"a":1,"b":[]},{"c":{}},[1,[2]]]`,
+		`{"multi":"one\ntwo\n","trail":"one\n\n","lead":"  x\ny","nonl":"a\nb"}`,
+		`{"quoted":["yes","no","null","1","1.5","0x1F","","-"," a","a ","a: b","a #b","#c","[x]","{y}","*z","&w","!v","|","'q'","\"q\""]}`,
+		`{"a b":1,"c: d":2,"":3,"1":4,"-":5,"é":6}`,
+		`{"nested":{"deep":{"list":[{"x":1,"y":[{"z":2}]}]}}}`,
+		`["\t","\u0001","tab\there"]`,
+		`"scalar"`,
+		`12.5e3`,
+		`{"a":[{}],"b":[[]]}`,
+	}
+	for _, json := range tests {
+		yaml := jsontoyaml([]byte(json))
+		back, lost, err := yamltojson(yaml)
+		if err != nil {
+			t.Errorf("%s: %v\n%s", json, err, yaml)
+			continue
+		}
+		if len(lost) > 0 {
+			t.Errorf("%s: lost %v", json, lost)
+		}
+		if got := string(compact(back)); got != json {
+			t.Errorf("%s:\ngot  %s\nyaml\n%s", json, got, strings.TrimSpace(string(yaml)))
+		}
+	}
+}